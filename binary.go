@@ -0,0 +1,471 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// The binary form is a format of this package only. It is not the binary
+// format defined by the OGDL specification, so it cannot be read by other
+// OGDL implementations, and their binary streams cannot be read by a
+// BinaryDecoder.
+//
+// The binary form of an OGDL flow value is its sequence of tokens without
+// the white space and comments between them. Lists and separators are
+// written as the single bytes "{", "}" and ",". A scalar starts with a byte
+// of its kind:
+//
+//	binaryText     length as an unsigned varint, then the text as written
+//	               in flow syntax, for references ^N, type annotations !T,
+//	               field names, nil and the scalars of other types
+//	binaryString   length as an unsigned varint, then the bytes of the
+//	               string, which are not quoted
+//	binaryInt      the integer as a signed varint
+//	binaryUint     the integer as an unsigned varint
+//	binaryFloat32  the 4 bytes of a float64 that is exactly a float32, in
+//	               IEEE 754 binary32 format, little endian
+//	binaryFloat64  the 8 bytes of a float64 in IEEE 754 binary64 format,
+//	               little endian
+//	binaryFalse    nothing
+//	binaryTrue     nothing
+//
+// Successive values follow each other directly.
+const (
+	binaryText = iota
+	binaryString
+	binaryInt
+	binaryUint
+	binaryFloat32
+	binaryFloat64
+	binaryFalse
+	binaryTrue
+
+	binaryListStart = '{'
+	binaryListEnd   = '}'
+	binarySep       = ','
+)
+
+// maxBinaryScalar is the length of the longest scalar a BinaryDecoder reads.
+const maxBinaryScalar = 1 << 30
+
+// A BinaryEncoder writes values to an output stream in the binary form of
+// OGDL flow defined by this package, which a BinaryDecoder reads without
+// scanning text. Values are
+// encoded as by an Encoder, with the same MatchFunc dispatch, references
+// and type annotations, except that booleans, numbers and strings are
+// written as typed scalars instead of text.
+type BinaryEncoder struct {
+	enc *Encoder
+}
+
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	enc := NewEncoder(w)
+	enc.binary = true
+	return &BinaryEncoder{enc}
+}
+
+// Encode writes the binary form of v to the output stream. If writing
+// fails, the error is returned by this and every later call.
+func (e *BinaryEncoder) Encode(v interface{}) error {
+	return e.enc.Encode(v)
+}
+
+// A BinaryDecoder reads and decodes successive values written by a
+// BinaryEncoder. Values are decoded exactly as by a Decoder, and a typed
+// scalar is stored directly in a value of its kind.
+type BinaryDecoder struct {
+	dec *Decoder
+}
+
+func NewBinaryDecoder(r io.Reader) *BinaryDecoder {
+	dec := NewDecoder(r)
+	dec.binary = true
+	return &BinaryDecoder{dec}
+}
+
+// Decode reads the next value from its input and stores it in the value
+// pointed to by v. At the end of input, Decode returns io.EOF.
+func (d *BinaryDecoder) Decode(v interface{}) error {
+	return d.dec.Decode(v)
+}
+
+// More reports whether there is another value in the input. It also returns
+// true on an input error, which is then returned by Decode.
+func (d *BinaryDecoder) More() bool {
+	return d.dec.More()
+}
+
+// InputOffset returns the byte offset in the input right after the last
+// decoded value.
+func (d *BinaryDecoder) InputOffset() int64 {
+	return d.dec.InputOffset()
+}
+
+// binaryOf returns the Composer passed to a ValueEncodeFunc as its
+// io.Writer if it writes the binary form, or nil for any other writer.
+func binaryOf(w io.Writer) *composer {
+	if b, ok := w.(interface {
+		binaryComposer() *composer
+	}); ok {
+		return b.binaryComposer()
+	}
+	return nil
+}
+
+func (t *composer) binaryComposer() *composer {
+	if t.binary {
+		return t
+	}
+	return nil
+}
+
+func (t *composer) composeBinaryList(length int, composeElem func(i int) error) error {
+	t.writeText()
+	t.write([]byte{binaryListStart})
+	for i := 0; i < length; i++ {
+		if i > 0 {
+			t.writeText()
+			t.write([]byte{binarySep})
+		}
+		if err := composeElem(i); err != nil {
+			return err
+		}
+	}
+	t.writeText()
+	t.write([]byte{binaryListEnd})
+	return nil
+}
+
+// writeText writes the text kept in binary mode, which is what a value
+// encoder writes instead of a typed scalar, as binary tokens. Text that is
+// a single scalar is written as it is, and any other text, like the words
+// of a custom EncodeFunc written at once, is scanned into tokens.
+func (t *composer) writeText() {
+	text := t.text
+	if len(text) == 0 {
+		return
+	}
+	t.text = text[:0]
+	if !bytes.ContainsAny(text, " \t\r\n{},") && !bytes.Contains(text, []byte("//")) && !bytes.Contains(text, []byte("/*")) {
+		t.putBytes(binaryText, text)
+		return
+	}
+	if len(bytes.TrimSpace(text)) == 0 {
+		return
+	}
+	s := newScanner(bytes.NewReader(text))
+	for s.Scan() {
+		switch tok := s.current(); tok.ID {
+		case tokenString:
+			t.putBytes(binaryText, tok.Value)
+		case tokenLeftBrace, tokenRightBrace, tokenComma:
+			t.write(tok.Value)
+		}
+	}
+	if s.Error() != nil && t.err == nil {
+		t.err = s.Error()
+	}
+}
+
+// putBytes writes a scalar of a kind that is followed by its length, after
+// the text kept so far.
+func (t *composer) putBytes(kind byte, p []byte) {
+	t.writeText()
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = kind
+	n := binary.PutUvarint(head[1:], uint64(len(p)))
+	t.write(head[:1+n])
+	t.write(p)
+}
+
+// putString is like putBytes for a string.
+func (t *composer) putString(kind byte, s string) error {
+	t.writeText()
+	var head [1 + binary.MaxVarintLen64]byte
+	head[0] = kind
+	n := binary.PutUvarint(head[1:], uint64(len(s)))
+	if _, err := t.write(head[:1+n]); err != nil {
+		return err
+	}
+	// unlike t.write, without converting s to []byte.
+	m, err := t.w.WriteString(s)
+	t.n += int64(m)
+	t.err = err
+	return err
+}
+
+func (t *composer) putInt(i int64) error {
+	t.writeText()
+	var buf [1 + binary.MaxVarintLen64]byte
+	buf[0] = binaryInt
+	n := binary.PutVarint(buf[1:], i)
+	_, err := t.write(buf[:1+n])
+	return err
+}
+
+func (t *composer) putUint(u uint64) error {
+	t.writeText()
+	var buf [1 + binary.MaxVarintLen64]byte
+	buf[0] = binaryUint
+	n := binary.PutUvarint(buf[1:], u)
+	_, err := t.write(buf[:1+n])
+	return err
+}
+
+// putFloat writes f in 4 bytes if it is exactly a float32, and in 8 bytes
+// otherwise.
+func (t *composer) putFloat(f float64) error {
+	t.writeText()
+	var buf [9]byte
+	n := 9
+	if f32 := float32(f); float64(f32) == f || math.IsNaN(f) {
+		buf[0] = binaryFloat32
+		binary.LittleEndian.PutUint32(buf[1:], math.Float32bits(f32))
+		n = 5
+	} else {
+		buf[0] = binaryFloat64
+		binary.LittleEndian.PutUint64(buf[1:], math.Float64bits(f))
+	}
+	_, err := t.write(buf[:n])
+	return err
+}
+
+func (t *composer) putBool(b bool) error {
+	t.writeText()
+	kind := byte(binaryFalse)
+	if b {
+		kind = binaryTrue
+	}
+	_, err := t.write([]byte{kind})
+	return err
+}
+
+// isTypedScalar reports whether the values of type t are written as typed
+// scalars in the binary form.
+func isTypedScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		return t != numberType
+	}
+	return false
+}
+
+// isTypedKey reports whether the map keys of type t are written as typed
+// scalars in the binary form, which are ordered by their values alone. Keys
+// of other types are written as their text.
+func isTypedKey(t reflect.Type) bool {
+	// a key of a named type is written with its type annotation.
+	return isTypedScalar(t) && t.Name() == t.Kind().String()
+}
+
+// binaryValue returns the text of the current scalar in binary form, as it
+// is written in flow syntax.
+func (s *scanner) binaryValue() []byte {
+	val := s.tok.Value
+	switch s.kind {
+	case binaryString:
+		s.text = append(s.text[:0], quoteString(string(val))...)
+	case binaryInt:
+		i, _ := binary.Varint(val)
+		s.text = strconv.AppendInt(s.text[:0], i, 10)
+	case binaryUint:
+		u, _ := binary.Uvarint(val)
+		s.text = strconv.AppendUint(s.text[:0], u, 10)
+	case binaryFloat32, binaryFloat64:
+		s.text = append(s.text[:0], formatFloat(s.binaryFloat(), &defaultOptions, 64)...)
+	case binaryFalse:
+		return []byte("false")
+	case binaryTrue:
+		return []byte("true")
+	default:
+		return val
+	}
+	return s.text
+}
+
+func (s *scanner) binaryFloat() float64 {
+	if s.kind == binaryFloat32 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(s.tok.Value)))
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(s.tok.Value))
+}
+
+// setBinaryScalar stores the current scalar in v if it is a typed scalar in
+// binary form that v takes without conversion, as the Encoding of
+// matchValue or matchInterface would, and reports whether it did. Any other
+// scalar is decoded from its text, which also reports the errors.
+func (s *scanner) setBinaryScalar(v reflect.Value) bool {
+	if !s.binary || s.tok.ID != tokenString || s.kind == binaryText || v.Type() == numberType {
+		return false
+	}
+	val := s.tok.Value
+	switch kind := v.Kind(); s.kind {
+	case binaryString:
+		switch {
+		case kind == reflect.String:
+			v.SetString(string(val))
+		case kind == reflect.Interface && v.NumMethod() == 0:
+			v.Set(reflect.ValueOf(string(val)))
+		default:
+			return false
+		}
+	case binaryInt:
+		i, _ := binary.Varint(val)
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(i) {
+				return false
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if i < 0 || v.OverflowUint(uint64(i)) {
+				return false
+			}
+			v.SetUint(uint64(i))
+		default:
+			return false
+		}
+	case binaryUint:
+		u, _ := binary.Uvarint(val)
+		switch kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if u > math.MaxInt64 || v.OverflowInt(int64(u)) {
+				return false
+			}
+			v.SetInt(int64(u))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if v.OverflowUint(u) {
+				return false
+			}
+			v.SetUint(u)
+		default:
+			return false
+		}
+	case binaryFloat32, binaryFloat64:
+		f := s.binaryFloat()
+		if kind != reflect.Float32 && kind != reflect.Float64 || v.OverflowFloat(f) {
+			return false
+		}
+		v.SetFloat(f)
+	case binaryFalse, binaryTrue:
+		b := s.kind == binaryTrue
+		switch {
+		case kind == reflect.Bool:
+			v.SetBool(b)
+		case kind == reflect.Interface && v.NumMethod() == 0:
+			v.Set(reflect.ValueOf(b))
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// scanBinary advances to the next token of input in binary form.
+func (s *scanner) scanBinary() bool {
+	s.pos = s.end
+	s.tok0 = s.off
+	c := s.peek(0)
+	if c < 0 {
+		if s.rerr != io.EOF {
+			s.err = s.rerr
+			return false
+		}
+		s.tok = token{ID: tokenEOF, Pos: s.pos.offset}
+		s.done = true
+		return true
+	}
+	var id, start, n int
+	switch c {
+	case binaryListStart:
+		id, n = tokenLeftBrace, 1
+	case binaryListEnd:
+		id, n = tokenRightBrace, 1
+	case binarySep:
+		id, n = tokenComma, 1
+	case binaryText, binaryString:
+		length, k := s.peekUvarint(1)
+		if k <= 0 || length > maxBinaryScalar || length > 0 && s.peek(k+int(length)) < 0 {
+			return s.binaryError(c)
+		}
+		id, start, n = tokenString, 1+k, 1+k+int(length)
+	case binaryInt, binaryUint:
+		_, k := s.peekUvarint(1)
+		if k <= 0 {
+			return s.binaryError(c)
+		}
+		id, start, n = tokenString, 1, 1+k
+	case binaryFloat32, binaryFloat64:
+		n = 5
+		if c == binaryFloat64 {
+			n = 9
+		}
+		if s.peek(n-1) < 0 {
+			return s.binaryError(c)
+		}
+		id, start = tokenString, 1
+	case binaryFalse, binaryTrue:
+		id, start, n = tokenString, 1, 1
+	default:
+		return s.binaryError(c)
+	}
+	s.tok = token{ID: id, Value: s.buf[s.off+start : s.off+n], Pos: s.pos.offset}
+	s.kind = binaryText
+	if id == tokenString {
+		s.kind = byte(c)
+	}
+	s.off += n
+	s.end.offset += n
+	s.end.column += n
+	return true
+}
+
+// peekUvarint returns the unsigned varint at the i-th byte of the unscanned
+// input and its length, or a length of 0 if the input ends within it and a
+// negative length if it overflows.
+func (s *scanner) peekUvarint(i int) (uint64, int) {
+	var buf [binary.MaxVarintLen64]byte
+	for j := range buf {
+		c := s.peek(i + j)
+		if c < 0 {
+			return 0, 0
+		}
+		buf[j] = byte(c)
+		if c < 0x80 {
+			return binary.Uvarint(buf[:j+1])
+		}
+	}
+	return 0, -1
+}
+
+func (s *scanner) binaryError(c int) bool {
+	if s.rerr != nil && s.rerr != io.EOF {
+		s.err = s.rerr
+		return false
+	}
+	msg := "invalid byte " + strconv.Quote(string(rune(c)))
+	if c <= binaryTrue {
+		msg = "invalid scalar"
+	}
+	s.tok = token{ID: tokenInvalid, Value: s.buf[s.off : s.off+1], Pos: s.pos.offset}
+	s.err = &SyntaxError{
+		msg:    msg,
+		Offset: int64(s.pos.offset),
+		Line:   s.pos.line,
+		Column: s.pos.column,
+		Token:  string(s.tok.Value),
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"h12.io/gspec"
+)
+
+var _ = gspec.Add(func(s gspec.S) {
+	describe, testcase := s.Alias("describe"), s.Alias("testcase")
+	expect := gspec.Expect(s.Fail)
+
+	describe("BinaryEncoder and BinaryDecoder", func() {
+		_encodingTestGroups.Test("round trip", s, func(tc encodingTestCase) {
+			var buf bytes.Buffer
+			expect(NewBinaryEncoder(&buf).Encode(tc.value)).Equal(nil)
+			nv := newValue(tc.value)
+			if nv.IsValid() {
+				dec := NewBinaryDecoder(&buf)
+				expect(dec.Decode(nv.Interface())).Equal(nil)
+				expect(nv.Elem().Interface()).Equal(tc.value)
+				expect(dec.More()).Equal(false)
+			}
+		})
+		testcase("tokens", func() {
+			var buf bytes.Buffer
+			enc := NewBinaryEncoder(&buf)
+			expect(enc.Encode(map[string][]int{"a": {1, 22}, "b": {}})).Equal(nil)
+			expect(enc.Encode([]interface{}{INT(3)})).Equal(nil)
+			expect(buf.String()).Equal("{\x01\x01a{\x02\x02,\x02,},\x01\x01b{}}" +
+				"{\x00\x04!INT\x02\x06}")
+		})
+		testcase("successive values and references", func() {
+			type node struct {
+				Name string
+				Next *node
+			}
+			n := &node{Name: "a"}
+			n.Next = n
+			var buf bytes.Buffer
+			enc := NewBinaryEncoder(&buf)
+			expect(enc.Encode(n)).Equal(nil)
+			expect(enc.Encode([]string{"x, y", ""})).Equal(nil)
+			dec := NewBinaryDecoder(&buf)
+			var m node
+			expect(dec.Decode(&m)).Equal(nil)
+			expect(m.Name).Equal("a")
+			expect(m.Next.Next).Equal(m.Next)
+			expect(dec.InputOffset()).Equal(int64(26))
+			expect(dec.More()).Equal(true)
+			var a []string
+			expect(dec.Decode(&a)).Equal(nil)
+			expect(a).Equal([]string{"x, y", ""})
+			expect(dec.Decode(&a)).Equal(io.EOF)
+		})
+		testcase("typed scalars", func() {
+			var buf bytes.Buffer
+			src := struct {
+				S    string
+				I    int
+				U    uint8
+				F, G float64
+				B    bool
+				N    []int
+			}{"^1", -1, 200, 1.5, 0.1, true, nil}
+			expect(NewBinaryEncoder(&buf).Encode(src)).Equal(nil)
+			expect(buf.String()).Equal("{\x00\x01S\x01\x02^1,\x00\x01I\x02\x01,\x00\x01U\x03\xc8\x01," +
+				"\x00\x01F\x04\x00\x00\xc0?,\x00\x01G\x05\x9a\x99\x99\x99\x99\x99\xb9?," +
+				"\x00\x01B\x07,\x00\x01N\x00\x03nil}")
+			var v interface{}
+			expect(NewBinaryDecoder(&buf).Decode(&v)).Equal(nil)
+			expect(v).Equal(Map{{"S", "^1"}, {"I", Number("-1")}, {"U", Number("200")},
+				{"F", Number("1.5")}, {"G", Number("0.1")}, {"B", true}, {"N", nil}})
+		})
+		testcase("typed scalars of other kinds", func() {
+			var buf bytes.Buffer
+			src := struct {
+				S, U int
+				F    float64
+				B    bool
+			}{3, 3, 2.5, true}
+			expect(NewBinaryEncoder(&buf).Encode(src)).Equal(nil)
+			var v struct {
+				S string
+				U uint
+				F float32
+				B string
+			}
+			expect(NewBinaryDecoder(&buf).Decode(&v)).Equal(nil)
+			expect(v.S).Equal("3")
+			expect(v.U).Equal(uint(3))
+			expect(v.F).Equal(float32(2.5))
+			expect(v.B).Equal("true")
+		})
+		testcase("range errors", func() {
+			var buf bytes.Buffer
+			expect(NewBinaryEncoder(&buf).Encode([]int{300, -1})).Equal(nil)
+			text := buf.String()
+			var a []int8
+			_, ok := NewBinaryDecoder(strings.NewReader(text)).Decode(&a).(*RangeError)
+			expect(ok).Equal(true)
+			var u []uint
+			_, ok = NewBinaryDecoder(strings.NewReader(text)).Decode(&u).(*RangeError)
+			expect(ok).Equal(true)
+		})
+		testcase("smaller than text", func() {
+			records := benchRecords(100)
+			var text, bin bytes.Buffer
+			expect(NewEncoder(&text).Encode(records)).Equal(nil)
+			expect(NewBinaryEncoder(&bin).Encode(records)).Equal(nil)
+			expect(bin.Len() < text.Len()).Equal(true)
+		})
+		testcase("errors", func() {
+			for _, src := range []string{
+				"\x00\x05abc", "\x00", "\x00\xff", "{\x00\x011", "\x01", "abc",
+				"\x02", "\x03\xff", "\x04\x00\x00", "\x05\x00", "\x08",
+			} {
+				var v interface{}
+				err := NewBinaryDecoder(strings.NewReader(src)).Decode(&v)
+				_, ok := err.(*SyntaxError)
+				expect(ok).Equal(true)
+			}
+		})
+	})
+})
+
+type benchRecord struct {
+	ID      int
+	Name    string
+	Tags    []string
+	Score   float64
+	Enabled bool
+	Attrs   map[string]string
+}
+
+func benchRecords(n int) []benchRecord {
+	records := make([]benchRecord, n)
+	for i := range records {
+		records[i] = benchRecord{
+			ID:      i,
+			Name:    fmt.Sprintf("record \"%d\"", i),
+			Tags:    []string{"alpha", "beta", "gamma"},
+			Score:   float64(i) / 7,
+			Enabled: i%2 == 0,
+			Attrs:   map[string]string{"path": "/var/lib/ogdl"},
+		}
+	}
+	return records
+}
+
+func benchmarkEncode(b *testing.B, newEncoder func(w io.Writer) func(v interface{}) error) {
+	records := benchRecords(10000)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := newEncoder(&buf)(records); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(int64(buf.Len()))
+}
+
+func benchmarkDecode(b *testing.B, newEncoder func(w io.Writer) func(v interface{}) error,
+	newDecoder func(r io.Reader) func(v interface{}) error) {
+	var buf bytes.Buffer
+	if err := newEncoder(&buf)(benchRecords(10000)); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var records []benchRecord
+		if err := newDecoder(bytes.NewReader(data))(&records); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func newTextEncoder(w io.Writer) func(v interface{}) error   { return NewEncoder(w).Encode }
+func newBinaryEncoder(w io.Writer) func(v interface{}) error { return NewBinaryEncoder(w).Encode }
+func newTextDecoder(r io.Reader) func(v interface{}) error   { return NewDecoder(r).Decode }
+func newBinaryDecoder(r io.Reader) func(v interface{}) error { return NewBinaryDecoder(r).Decode }
+
+func BenchmarkEncode(b *testing.B)       { benchmarkEncode(b, newTextEncoder) }
+func BenchmarkBinaryEncode(b *testing.B) { benchmarkEncode(b, newBinaryEncoder) }
+func BenchmarkDecode(b *testing.B)       { benchmarkDecode(b, newTextEncoder, newTextDecoder) }
+func BenchmarkBinaryDecode(b *testing.B) { benchmarkDecode(b, newBinaryEncoder, newBinaryDecoder) }
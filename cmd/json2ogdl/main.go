@@ -0,0 +1,50 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Json2ogdl converts a JSON document to OGDL flow as flow.FromJSON does,
+// in the canonical layout of ogdlfmt.
+//
+// Usage:
+//
+//	json2ogdl [file]
+//
+// Without a file, it converts the standard input.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ogdl/flow"
+)
+
+func main() {
+	if err := convert(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func convert(args []string) error {
+	var src []byte
+	var err error
+	switch len(args) {
+	case 0:
+		src, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		src, err = ioutil.ReadFile(args[0])
+	default:
+		return fmt.Errorf("usage: json2ogdl [file]")
+	}
+	if err != nil {
+		return err
+	}
+	res, err := flow.FromJSON(src)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(res)
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ogdl2json converts an OGDL flow document to JSON as flow.ToJSON does.
+//
+// Usage:
+//
+//	ogdl2json [-c] [file]
+//
+// Without a file, it converts the standard input. The JSON is indented by
+// two spaces unless -c is given.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ogdl/flow"
+)
+
+var compact = flag.Bool("c", false, "write compact JSON instead of indenting it")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ogdl2json [flags] [file]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if err := convert(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+func convert() error {
+	var src []byte
+	var err error
+	switch flag.NArg() {
+	case 0:
+		src, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		src, err = ioutil.ReadFile(flag.Arg(0))
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := flow.ToJSON(src)
+	if err != nil {
+		return err
+	}
+	if !*compact {
+		var buf bytes.Buffer
+		json.Indent(&buf, res, "", "  ")
+		res = buf.Bytes()
+	}
+	_, err = os.Stdout.Write(append(res, '\n'))
+	return err
+}
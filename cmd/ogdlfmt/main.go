@@ -0,0 +1,199 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ogdlfmt formats OGDL flow documents in the canonical layout of
+// flow.Format, keeping their comments.
+//
+// Usage:
+//
+//	ogdlfmt [flags] [path ...]
+//
+// Without paths, it formats the standard input. A directory is searched
+// recursively for .ogdl files. By default, the formatted documents are
+// written to the standard output.
+//
+// The flags are:
+//
+//	-d
+//		Print a diff instead of the formatted document.
+//	-l
+//		List the files whose formatting differs from ogdlfmt's.
+//	-w
+//		Write the formatted document back to its file.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ogdl/flow"
+)
+
+var (
+	list  = flag.Bool("l", false, "list files whose formatting differs from ogdlfmt's")
+	write = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	diffs = flag.Bool("d", false, "display diffs instead of rewriting files")
+)
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ogdlfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			report(fmt.Errorf("error: cannot use -w with standard input"))
+		} else if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+	for _, path := range flag.Args() {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			report(err)
+		case info.IsDir():
+			walkDir(path)
+		default:
+			if err := processFile(path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func walkDir(path string) {
+	filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && isOGDLFile(info) {
+			err = processFile(path, nil, os.Stdout)
+		}
+		if err != nil {
+			report(err)
+		}
+		return nil
+	})
+}
+
+func isOGDLFile(info os.FileInfo) bool {
+	name := info.Name()
+	return !info.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".ogdl")
+}
+
+// processFile formats the file filename, read from in if it is not nil.
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	var perm os.FileMode = 0644
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		in, perm = f, info.Mode().Perm()
+	}
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	res, err := flow.Format(src)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if !bytes.Equal(src, res) {
+		if *list {
+			fmt.Fprintln(out, filename)
+		}
+		if *write {
+			if err := ioutil.WriteFile(filename, res, perm); err != nil {
+				return err
+			}
+		}
+		if *diffs {
+			d, err := diff(src, res, filename)
+			if err != nil {
+				return fmt.Errorf("computing diff: %s", err)
+			}
+			fmt.Fprintf(out, "diff -u %s %s\n", filepath.ToSlash(filename+".orig"), filepath.ToSlash(filename))
+			out.Write(d)
+		}
+	}
+	if !*list && !*write && !*diffs {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+// diff returns the unified diff of b1 and b2 from the diff command, with
+// the file names in its header replaced by filename.
+func diff(b1, b2 []byte, filename string) ([]byte, error) {
+	f1, err := writeTempFile("ogdlfmt", b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTempFile("ogdlfmt", b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files differ.
+		return replaceTempFilename(data, filename)
+	}
+	return data, err
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// replaceTempFilename replaces the temporary file names in the first two
+// lines of a unified diff with filename.orig and filename.
+func replaceTempFilename(diff []byte, filename string) ([]byte, error) {
+	lines := bytes.SplitN(diff, []byte("\n"), 3)
+	if len(lines) < 3 || !bytes.HasPrefix(lines[0], []byte("--- ")) ||
+		!bytes.HasPrefix(lines[1], []byte("+++ ")) {
+		return nil, fmt.Errorf("malformed diff output: %s", diff)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s.orig\n", filepath.ToSlash(filename))
+	fmt.Fprintf(&buf, "+++ %s\n", filepath.ToSlash(filename))
+	buf.Write(lines[2])
+	return buf.Bytes(), nil
+}
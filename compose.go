@@ -5,9 +5,11 @@
 package flow
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"reflect"
+	"strings"
 )
 
 type SyntaxComposer interface {
@@ -21,80 +23,257 @@ type Composer interface {
 	ComposeAny(v reflect.Value) error
 }
 
+// composer writes the composed text through a buffered writer. The first
+// write error is kept and returned by every later write.
 type composer struct {
-	bytes.Buffer
+	w        *bufio.Writer
+	n        int64 // bytes written
+	err      error
+	last     byte // last byte written
+	col      int  // bytes written since the last line break
 	indented bool
 	prefix   string
 	indent   string
 	depth    int
+	keyWidth int // width that the keys of the next list are aligned to
+	binary   bool
+	text     []byte // text written in binary mode and not yet written as tokens
+	layout
+	style
+	opts encodeOptions
+}
+
+// style holds the settings of the layout of lists.
+type style struct {
+	noTrailingComma bool
+	compactSep      bool
+	maxWidth        int
+	alignNested     bool
+}
+
+// encodeOptions holds the settings that change how scalar values are
+// written.
+type encodeOptions struct {
+	hexUint     bool
+	floatFormat byte // 0 for the shortest 'g' representation
+	floatPrec   int
+}
+
+var defaultOptions encodeOptions
+
+// optionsOf returns the options of the Composer passed to a ValueEncodeFunc
+// as its io.Writer, or the defaults for any other writer.
+func optionsOf(w io.Writer) *encodeOptions {
+	if o, ok := w.(interface {
+		options() *encodeOptions
+	}); ok {
+		return o.options()
+	}
+	return &defaultOptions
+}
+
+func newComposer(w io.Writer) composer {
+	return composer{
+		w:     bufio.NewWriter(w),
+		style: style{maxWidth: defaultMaxWidth},
+	}
+}
+
+// Write writes p as a text of the layout when indented, and keeps it to be
+// written as binary tokens in binary mode.
+func (t *composer) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if t.binary {
+		t.text = append(t.text, p...)
+		return len(p), nil
+	}
+	if t.indented {
+		t.emit(layoutToken{kind: layoutText, text: string(p)})
+		return len(p), t.err
+	}
+	return t.write(p)
+}
+
+func (t *composer) WriteString(s string) (int, error) {
+	return t.Write([]byte(s))
+}
+
+func (t *composer) writeRaw(s string) {
+	t.write([]byte(s))
+}
+
+func (t *composer) write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.w.Write(p)
+	t.n += int64(n)
+	if n > 0 {
+		t.last = p[n-1]
+		if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+			t.col = n - i - 1
+		} else {
+			t.col += n
+		}
+	}
+	t.err = err
+	return n, err
+}
+
+func (t *composer) flush() error {
+	if t.binary {
+		t.writeText()
+	}
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+func (t *composer) options() *encodeOptions {
+	return &t.opts
 }
 
 func (t *composer) Indented() bool {
 	return t.indented
 }
 
+// ComposeList writes a list on one line if it fits within the maximum width
+// when indented, and one element per line otherwise.
 func (t *composer) ComposeList(length int, composeElem func(i int) error) error {
-	t.listStart(length)
+	if t.binary {
+		return t.composeBinaryList(length, composeElem)
+	}
+	if length == 0 {
+		t.WriteString("{}")
+		return nil
+	}
+	if !t.indented {
+		t.WriteString("{")
+		for i := 0; i < length; i++ {
+			if i > 0 {
+				t.WriteString(t.sep())
+			}
+			if err := composeElem(i); err != nil {
+				return err
+			}
+		}
+		t.WriteString("}")
+		return nil
+	}
+	t.emit(layoutToken{kind: layoutGroupStart})
+	t.WriteString("{")
+	t.emit(layoutToken{kind: layoutNest})
+	t.emit(layoutToken{kind: layoutLine})
 	for i := 0; i < length; i++ {
 		if i > 0 {
-			t.listSep()
+			t.WriteString(",")
+			t.emit(layoutToken{kind: layoutLine, text: strings.TrimPrefix(t.sep(), ",")})
 		}
 		if err := composeElem(i); err != nil {
 			return err
 		}
 	}
-	t.listEnd(length)
+	if !t.noTrailingComma {
+		t.emit(layoutToken{kind: layoutIfBreak, text: ","})
+	}
+	t.emit(layoutToken{kind: layoutUnnest})
+	t.emit(layoutToken{kind: layoutLine})
+	t.WriteString("}")
+	t.emit(layoutToken{kind: layoutGroupEnd})
 	return nil
 }
 
+// pad writes n spaces to align the values of a list that is broken over
+// lines.
+func (t *composer) pad(n int) {
+	if n == 0 {
+		return
+	}
+	if !t.indented {
+		t.WriteString(strings.Repeat(" ", n))
+		return
+	}
+	t.emit(layoutToken{kind: layoutIfBreak, text: strings.Repeat(" ", n)})
+}
+
+func (t *composer) alignsNested() bool {
+	return t.alignNested
+}
+
+func (t *composer) setKeyWidth(n int) {
+	t.keyWidth = n
+}
+
+func (t *composer) takeKeyWidth() int {
+	n := t.keyWidth
+	t.keyWidth = 0
+	return n
+}
+
+func (t *composer) sep() string {
+	if t.compactSep {
+		return ","
+	}
+	return ", "
+}
+
 func (t *composer) start(prefix, indent string) {
 	t.indented = true
 	t.prefix = prefix
 	t.indent = indent
-	t.depth = 0
-	t.WriteString(prefix)
+	t.reset()
+	t.writeRaw(prefix)
 }
 
 func (t *composer) stop() {
 	t.indented = false
 }
 
+// reset drops the state of the layout left by a failed composition.
+func (t *composer) reset() {
+	t.depth = 0
+	t.keyWidth = 0
+	t.layout = layout{buf: t.buf[:0]}
+}
+
 func (t *composer) newLine() {
-	t.WriteString("\n")
-	t.WriteString(t.prefix)
+	t.writeRaw("\n")
+	t.writeRaw(t.prefix)
 	for i := 0; i < t.depth; i++ {
-		t.WriteString(t.indent)
+		t.writeRaw(t.indent)
 	}
 }
 
-func (t *composer) listStart(count int) {
-	t.WriteString("{")
-	if t.indented {
-		if count > 0 {
-			t.depth++
-			t.newLine()
-		}
+// composeKey writes the key of a list element followed by a space, or as
+// binary tokens in binary mode, a typed scalar for a typed map key.
+func (t *composer) composeKey(text string, value reflect.Value) error {
+	if !t.binary {
+		t.WriteString(text)
+		_, err := t.WriteString(" ")
+		return err
 	}
-}
-
-func (t *composer) listSep() {
-	if t.indented {
-		t.WriteString(",")
-		t.newLine()
-	} else {
-		t.WriteString(", ")
+	switch {
+	case !value.IsValid():
+		return t.putString(binaryText, text)
+	case isTypedKey(value.Type()):
+		return typeToValueEncoding[value.Kind()].Encode(value, t)
 	}
+	// the text of any other key may be a list.
+	t.WriteString(text)
+	t.writeText()
+	return t.err
 }
 
-func (t *composer) listEnd(count int) {
-	if t.indented {
-		if count > 0 {
-			t.depth--
-			t.WriteString(",")
-			t.newLine()
-		}
+// writeAnnotation writes a reference or a type annotation before a value.
+func (t *composer) writeAnnotation(word string) {
+	if t.binary {
+		t.putString(binaryText, word)
+		return
 	}
-	t.WriteString("}")
+	t.WriteString(word + " ")
 }
 
 func (t *composer) encodeNil() {
@@ -0,0 +1,141 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package convert translates YAML and TOML documents to and from OGDL flow
+// through the document tree of flow.Node.
+//
+// A mapping or table is translated to a list of key value pairs in the same
+// order and a sequence or array to a list. Strings are quoted in OGDL flow,
+// so that an unquoted scalar is always a number, nil, true or false, which
+// are translated to the corresponding scalars of the other format. Comments
+// are not kept.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/ogdl/flow"
+)
+
+// scalar is the value of an OGDL flow scalar: nil, a bool, a flow.Number or
+// a string.
+func scalar(text string) (interface{}, error) {
+	var v interface{}
+	if err := flow.Unmarshal([]byte(text), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// flowString returns s as a quoted OGDL flow scalar.
+func flowString(s string) string {
+	b, _ := flow.Marshal(s)
+	return string(b)
+}
+
+// flowKey returns the OGDL flow scalar of a string key, which is not quoted
+// if it is read back as the same string.
+func flowKey(key string) string {
+	if flow.IsBareString(key) {
+		return key
+	}
+	return flowString(key)
+}
+
+// keyString returns the string of a key of an OGDL flow list of pairs.
+func keyString(key *flow.Node) (string, error) {
+	if key.Kind != flow.ScalarNode {
+		return "", fmt.Errorf("cannot convert a key that is not a scalar")
+	}
+	v, err := scalar(key.Text)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "nil", nil
+	}
+	return key.Text, nil
+}
+
+// writeFlow writes a document tree in the canonical layout of flow.Format.
+func writeFlow(root *flow.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	doc := &flow.Node{Kind: flow.DocumentNode, Children: []*flow.Node{root}}
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return flow.Format(buf.Bytes())
+}
+
+// parseFlow returns the root value of an OGDL flow document, nil if it is
+// empty.
+func parseFlow(src []byte) (*flow.Node, error) {
+	doc, err := flow.Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range doc.Children {
+		if child.Kind != flow.CommentNode {
+			return child, nil
+		}
+	}
+	return nil, nil
+}
+
+// elements returns the children of a list that are not comments, and
+// whether they are all key value pairs.
+func elements(list *flow.Node) (elems []*flow.Node, pairs bool, err error) {
+	npairs := 0
+	for _, child := range list.Children {
+		if child.Kind == flow.CommentNode {
+			continue
+		}
+		if (child.Kind == flow.ScalarNode || child.Kind == flow.ListNode) && child.Value != nil {
+			npairs++
+		}
+		elems = append(elems, child)
+	}
+	if npairs > 0 && npairs < len(elems) {
+		return nil, false, fmt.Errorf("cannot convert a list mixing key value pairs and values")
+	}
+	return elems, npairs > 0, nil
+}
+
+// annotations splits the value chain n into the ID of the reference it
+// defines, its type and its value.
+func annotations(n *flow.Node) (id, typ string, v *flow.Node, err error) {
+	v = n
+	if v.Kind == flow.RefNode && v.Value != nil {
+		id, v = v.Text, v.Value
+	}
+	if v.Kind == flow.TypeNode && v.Value != nil {
+		typ, v = v.Text, v.Value
+	}
+	if v.Kind == flow.TypeNode || v.Value != nil {
+		return "", "", nil, fmt.Errorf("cannot convert the value %q", v.Text)
+	}
+	return id, typ, v, nil
+}
+
+// integer returns the value of an integer numeral, or false if num is not
+// an integer.
+func integer(num flow.Number) (*big.Int, bool) {
+	return new(big.Int).SetString(string(num), 0)
+}
+
+// float returns the value of a floating point numeral, or false if num is
+// complex.
+func float(num flow.Number) (float64, bool) {
+	f, err := num.Float64()
+	if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+		return f, true
+	}
+	return f, err == nil
+}
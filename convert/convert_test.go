@@ -0,0 +1,132 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"h12.io/gspec"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestAll(t *testing.T) {
+	gspec.Test(t)
+}
+
+// golden returns the content of a golden file, writing got to it first if
+// the -update flag is set.
+func golden(file string, got []byte) []byte {
+	if *update {
+		if err := ioutil.WriteFile(file, got, 0644); err != nil {
+			panic(err)
+		}
+	}
+	b, _ := ioutil.ReadFile(file)
+	return b
+}
+
+type format struct {
+	ext      string
+	toFlow   func([]byte) ([]byte, error)
+	fromFlow func([]byte) ([]byte, error)
+}
+
+var formats = []format{
+	{".yaml", YAMLToFlow, FlowToYAML},
+	{".toml", TOMLToFlow, FlowToTOML},
+}
+
+var _ = gspec.Add(func(s gspec.S) {
+	describe, testcase := s.Alias("describe"), s.Alias("testcase")
+	expect := gspec.Expect(s.Fail)
+
+	// For each testdata/NAME.EXT, NAME.ogdl is the OGDL flow document of it
+	// and NAME.out.EXT is the document converted back.
+	for _, f := range formats {
+		f := f
+		inputs, _ := filepath.Glob("testdata/*" + f.ext)
+		describe(strings.TrimPrefix(f.ext, ".")+" golden files", func() {
+			for _, in := range inputs {
+				in := in
+				if strings.HasSuffix(in, ".out"+f.ext) {
+					continue
+				}
+				base := strings.TrimSuffix(in, f.ext)
+				testcase(filepath.Base(base), func() {
+					src, err := ioutil.ReadFile(in)
+					expect(err).Equal(nil)
+					doc, err := f.toFlow(src)
+					expect(err).Equal(nil)
+					expect(string(doc)).Equal(string(golden(base+".ogdl", doc)))
+					out, err := f.fromFlow(doc)
+					expect(err).Equal(nil)
+					expect(string(out)).Equal(string(golden(base+".out"+f.ext, out)))
+
+					// the output is stable when converted again.
+					doc, err = f.toFlow(out)
+					expect(err).Equal(nil)
+					again, err := f.fromFlow(doc)
+					expect(err).Equal(nil)
+					expect(string(again)).Equal(string(out))
+				})
+			}
+		})
+	}
+
+	describe("YAMLToFlow", func() {
+		testcase("anchors", func() {
+			out, err := YAMLToFlow([]byte("a: &x [1]\nb: *x\nc: &y !T 2\nd: *y\n"))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal("{a ^1 {1}, b ^1, c ^2 !T 2, d ^2}\n")
+		})
+		testcase("errors", func() {
+			for _, src := range []string{
+				"a: *x", "[1, 2", "a: 1\n---\nb: 2", "{[1]: 2}", "a: \"\\q\"",
+				"a: !!int x", "a: !!int 1.5", "a: !!bool 1", "a: !!null 0", "a: !!float \"\"",
+				"a: !!int [1]", "a: !!seq {}", "!!map [1]", "!!str [1]", "!!int x: 1",
+			} {
+				_, err := YAMLToFlow([]byte(src))
+				expect(err).NotEqual(nil)
+			}
+		})
+	})
+
+	describe("FlowToYAML", func() {
+		testcase("references", func() {
+			out, err := FlowToYAML([]byte("{^1 !!set {a nil}, ^1, {x ^2 \"s\", y ^2}}"))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal("- &1 !!set\n  a: null\n- *1\n- x: &2 s\n  y: *2\n")
+		})
+		testcase("errors", func() {
+			for _, src := range []string{"{a 1, b}", "{a"} {
+				_, err := FlowToYAML([]byte(src))
+				expect(err).NotEqual(nil)
+			}
+		})
+	})
+
+	describe("FlowToTOML", func() {
+		testcase("errors", func() {
+			for _, src := range []string{"{1, 2}", "{a nil}", "{a ^1 1}", "{a !T 1}", "{a 1i}", "{a !datetime \"x\"}"} {
+				_, err := FlowToTOML([]byte(src))
+				expect(err).NotEqual(nil)
+			}
+		})
+	})
+
+	describe("TOMLToFlow", func() {
+		testcase("errors", func() {
+			for _, src := range []string{"a = 1\na = 2", "[t]\n[t]", "a = ", "a = 1 b = 2", "a = {b = 1}\n[a]", "s = \"x"} {
+				_, err := TOMLToFlow([]byte(src))
+				expect(err).NotEqual(nil)
+			}
+		})
+	})
+})
@@ -0,0 +1,317 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ogdl/flow"
+)
+
+// tomlDateTimeType is the type annotation of the dates and times of TOML.
+const tomlDateTimeType = "datetime"
+
+// TOMLToFlow converts a TOML document to an OGDL flow document in the
+// canonical layout of flow.Format. A date or a time is translated to its
+// text annotated with !datetime.
+func TOMLToFlow(src []byte) ([]byte, error) {
+	root, err := parseTOML(src)
+	if err != nil {
+		return nil, err
+	}
+	return writeFlow(tomlNode(root))
+}
+
+func tomlNode(v interface{}) *flow.Node {
+	switch v := v.(type) {
+	case *tomlTable:
+		list := &flow.Node{Kind: flow.ListNode}
+		for _, key := range v.keys {
+			list.Children = append(list.Children, &flow.Node{
+				Kind:  flow.ScalarNode,
+				Text:  flowKey(key),
+				Value: tomlNode(v.values[key]),
+			})
+		}
+		return list
+	case *tomlArray:
+		list := &flow.Node{Kind: flow.ListNode}
+		for _, elem := range v.elems {
+			list.Children = append(list.Children, tomlNode(elem))
+		}
+		return list
+	}
+	return v.(*flow.Node)
+}
+
+// FlowToTOML converts an OGDL flow document to a TOML document, the reverse
+// of TOMLToFlow. The document must be a list of key value pairs. A list of
+// pairs is written as a table and a list of such lists as an array of
+// tables, after the other values of the table that contains them. An empty
+// list is written as an empty array. nil and references cannot be
+// converted.
+func FlowToTOML(src []byte) ([]byte, error) {
+	root, err := parseFlow(src)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, nil
+	}
+	var e tomlEmitter
+	pairs, ok := tomlPairs(root)
+	if !ok {
+		if elems, _, err := elements(root); root.Kind != flow.ListNode || err != nil || len(elems) > 0 {
+			return nil, fmt.Errorf("toml: the document is not a table")
+		}
+	}
+	if err := e.table(nil, pairs, false); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+type tomlEmitter struct {
+	buf bytes.Buffer
+}
+
+// table writes the pairs of the table at path, with a header if the table
+// has values of its own or if array is true.
+func (e *tomlEmitter) table(path []string, pairs []*flow.Node, array bool) error {
+	var values, tables, arrays []*flow.Node
+	for _, pair := range pairs {
+		switch {
+		case isTOMLTable(pair.Value):
+			tables = append(tables, pair)
+		case isTOMLArrayOfTables(pair.Value):
+			arrays = append(arrays, pair)
+		default:
+			values = append(values, pair)
+		}
+	}
+	if path != nil && (array || len(values) > 0) {
+		if e.buf.Len() > 0 {
+			e.buf.WriteByte('\n')
+		}
+		header := strings.Join(path, ".")
+		if array {
+			header = "[" + header + "]"
+		}
+		e.buf.WriteString("[" + header + "]\n")
+	}
+	for _, pair := range values {
+		key, err := tomlKey(pair)
+		if err != nil {
+			return err
+		}
+		e.buf.WriteString(key + " = ")
+		if err := e.value(pair.Value); err != nil {
+			return err
+		}
+		e.buf.WriteByte('\n')
+	}
+	for _, pair := range tables {
+		key, err := tomlKey(pair)
+		if err != nil {
+			return err
+		}
+		sub, _ := tomlPairs(pair.Value)
+		if err := e.table(append(path[:len(path):len(path)], key), sub, false); err != nil {
+			return err
+		}
+	}
+	for _, pair := range arrays {
+		key, err := tomlKey(pair)
+		if err != nil {
+			return err
+		}
+		elems, _, _ := elements(pair.Value)
+		for _, elem := range elems {
+			sub, _ := tomlPairs(elem)
+			if err := e.table(append(path[:len(path):len(path)], key), sub, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// value writes an inline value.
+func (e *tomlEmitter) value(n *flow.Node) error {
+	id, typ, v, err := annotations(n)
+	switch {
+	case err != nil:
+		return err
+	case id != "" || v.Kind == flow.RefNode:
+		return fmt.Errorf("toml: cannot convert the reference ^%s", v.Text)
+	case typ == tomlDateTimeType && v.Kind == flow.ScalarNode:
+		s, err := scalar(v.Text)
+		if s, ok := s.(string); err == nil && ok && tomlDateTime.MatchString(s) {
+			e.buf.WriteString(s)
+			return nil
+		}
+		return fmt.Errorf("toml: invalid date or time %s", v.Text)
+	case typ != "":
+		return fmt.Errorf("toml: cannot convert the type annotation !%s", typ)
+	case v.Kind == flow.ScalarNode:
+		s, err := tomlText(v.Text)
+		if err != nil {
+			return err
+		}
+		e.buf.WriteString(s)
+		return nil
+	}
+	elems, pairs, err := elements(v)
+	if err != nil {
+		return err
+	}
+	open, close := "[", "]"
+	if pairs {
+		open, close = "{", "}"
+	}
+	e.buf.WriteString(open)
+	for i, elem := range elems {
+		if i > 0 {
+			e.buf.WriteString(", ")
+		}
+		if pairs {
+			key, err := tomlKey(elem)
+			if err != nil {
+				return err
+			}
+			e.buf.WriteString(key + " = ")
+			elem = elem.Value
+		}
+		if err := e.value(elem); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteString(close)
+	return nil
+}
+
+// tomlPairs returns the pairs of a list that is written as a table.
+func tomlPairs(n *flow.Node) ([]*flow.Node, bool) {
+	if n.Kind != flow.ListNode || n.Value != nil {
+		return nil, false
+	}
+	elems, pairs, err := elements(n)
+	if err != nil || !pairs {
+		return nil, false
+	}
+	return elems, true
+}
+
+func isTOMLTable(n *flow.Node) bool {
+	_, ok := tomlPairs(n)
+	return ok
+}
+
+func isTOMLArrayOfTables(n *flow.Node) bool {
+	if n.Kind != flow.ListNode || n.Value != nil {
+		return false
+	}
+	elems, pairs, err := elements(n)
+	if err != nil || pairs {
+		return false
+	}
+	// an empty list is an empty table if another element is a table.
+	tables := 0
+	for _, elem := range elems {
+		switch {
+		case isTOMLTable(elem):
+			tables++
+		case elem.Kind != flow.ListNode || elem.Value != nil || len(elem.Children) > 0:
+			return false
+		}
+	}
+	return tables > 0
+}
+
+func tomlKey(pair *flow.Node) (string, error) {
+	s, err := keyString(pair)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTOMLBare(s[i]) {
+			return tomlQuote(s), nil
+		}
+	}
+	if s == "" {
+		return `""`, nil
+	}
+	return s, nil
+}
+
+// tomlText returns the TOML value of an OGDL flow scalar.
+func tomlText(text string) (string, error) {
+	v, err := scalar(text)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", fmt.Errorf("toml: cannot convert nil")
+	case bool:
+		return strconv.FormatBool(v), nil
+	case flow.Number:
+		return tomlNumber(v)
+	}
+	return tomlQuote(v.(string)), nil
+}
+
+func tomlNumber(num flow.Number) (string, error) {
+	s := string(num)
+	if i, ok := integer(num); ok {
+		if tomlPrefixed.MatchString(s) {
+			return s, nil
+		}
+		return i.String(), nil
+	}
+	f, ok := float(num)
+	switch {
+	case !ok:
+		return "", fmt.Errorf("toml: cannot convert the number %s", s)
+	case math.IsNaN(f):
+		return "nan", nil
+	case math.IsInf(f, 1):
+		return "inf", nil
+	case math.IsInf(f, -1):
+		return "-inf", nil
+	case tomlFloat.MatchString(s):
+		return s, nil
+	}
+	s = strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s, nil
+}
+
+var tomlQuoted = map[rune]string{
+	'\b': `\b`, '\t': `\t`, '\n': `\n`, '\f': `\f`, '\r': `\r`, '"': `\"`, '\\': `\\`,
+}
+
+// tomlQuote returns s as a basic string.
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if q, ok := tomlQuoted[r]; ok {
+			b.WriteString(q)
+		} else if r < 0x20 || r == 0x7f {
+			fmt.Fprintf(&b, `\u%04x`, r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,548 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ogdl/flow"
+)
+
+// tomlTable is a table of a TOML document with its keys in the order they
+// are defined.
+type tomlTable struct {
+	keys    []string
+	values  map[string]interface{} // *tomlTable, *tomlArray or a scalar *flow.Node
+	defined bool                   // by a header or a key value pair, not only implied by another key
+	inline  bool
+}
+
+func newTOMLTable() *tomlTable {
+	return &tomlTable{values: make(map[string]interface{})}
+}
+
+func (t *tomlTable) set(key string, v interface{}) {
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = v
+}
+
+// tomlArray is an array of a TOML document, either a value or an array of
+// tables defined by [[headers]].
+type tomlArray struct {
+	elems  []interface{}
+	tables bool
+}
+
+// tomlParser parses a TOML 1.0 document.
+type tomlParser struct {
+	src []byte
+	pos int
+}
+
+func parseTOML(src []byte) (*tomlTable, error) {
+	p := &tomlParser{src: src}
+	root := newTOMLTable()
+	table := root
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return root, nil
+		}
+		var err error
+		if p.peek(0) == '[' {
+			table, err = p.parseHeader(root)
+		} else {
+			err = p.parseKeyValue(table)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skipInline()
+		if !p.eof() && p.peek(0) != '\n' && !(p.peek(0) == '\r' && p.peek(1) == '\n') {
+			return nil, p.errorf("unexpected %q", p.rest())
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek(i int) byte {
+	if p.pos+i < len(p.src) {
+		return p.src[p.pos+i]
+	}
+	return 0
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	line := bytes.Count(p.src[:p.pos], []byte("\n")) + 1
+	return fmt.Errorf("toml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// rest returns the rest of the current line.
+func (p *tomlParser) rest() string {
+	end := bytes.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return string(p.src[p.pos:])
+	}
+	return strings.TrimRight(string(p.src[p.pos:p.pos+end]), "\r")
+}
+
+// skipInline skips spaces and a comment up to the end of the line.
+func (p *tomlParser) skipInline() {
+	for !p.eof() {
+		switch p.src[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '#':
+			for !p.eof() && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			return
+		default:
+			return
+		}
+	}
+}
+
+// skipBlank skips spaces, comments and line breaks.
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipInline()
+		if p.eof() || p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+			return
+		}
+		p.pos++
+	}
+}
+
+// parseHeader parses a [table] or [[array of tables]] header and returns
+// the table it starts.
+func (p *tomlParser) parseHeader(root *tomlTable) (*tomlTable, error) {
+	array := p.peek(1) == '['
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	p.skipInline()
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	p.skipInline()
+	if p.peek(0) != ']' || array && p.peek(1) != ']' {
+		return nil, p.errorf("unterminated table header")
+	}
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	parent, err := p.walk(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := keys[len(keys)-1]
+	table := newTOMLTable()
+	table.defined = true
+	switch v := parent.values[key].(type) {
+	case nil:
+		if array {
+			parent.set(key, &tomlArray{elems: []interface{}{table}, tables: true})
+		} else {
+			parent.set(key, table)
+		}
+		return table, nil
+	case *tomlArray:
+		if array && v.tables {
+			v.elems = append(v.elems, table)
+			return table, nil
+		}
+	case *tomlTable:
+		if !array && !v.defined && !v.inline {
+			v.defined = true
+			return v, nil
+		}
+	}
+	return nil, p.errorf("%q is defined twice", strings.Join(keys, "."))
+}
+
+// walk returns the table of a dotted key, creating the tables that do not
+// exist yet. A key of an array of tables is the last table in it.
+func (p *tomlParser) walk(table *tomlTable, keys []string) (*tomlTable, error) {
+	for _, key := range keys {
+		switch v := table.values[key].(type) {
+		case nil:
+			t := newTOMLTable()
+			table.set(key, t)
+			table = t
+		case *tomlTable:
+			if v.inline {
+				return nil, p.errorf("cannot extend the inline table %q", key)
+			}
+			table = v
+		case *tomlArray:
+			if !v.tables {
+				return nil, p.errorf("cannot extend the array %q", key)
+			}
+			table = v.elems[len(v.elems)-1].(*tomlTable)
+		default:
+			return nil, p.errorf("%q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) parseKeyValue(table *tomlTable) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipInline()
+	if p.peek(0) != '=' {
+		return p.errorf("expected = after a key")
+	}
+	p.pos++
+	p.skipInline()
+	v, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys[:len(keys)-1] {
+		switch t := table.values[key].(type) {
+		case nil:
+			sub := newTOMLTable()
+			sub.defined = true
+			table.set(key, sub)
+			table = sub
+		case *tomlTable:
+			if t.inline {
+				return p.errorf("cannot extend the inline table %q", key)
+			}
+			table = t
+		default:
+			return p.errorf("%q is not a table", key)
+		}
+	}
+	key := keys[len(keys)-1]
+	if _, ok := table.values[key]; ok {
+		return p.errorf("%q is defined twice", strings.Join(keys, "."))
+	}
+	table.set(key, v)
+	return nil
+}
+
+// parseKey parses a key of dot separated bare or quoted keys.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		var key string
+		switch c := p.peek(0); {
+		case c == '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case c == '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isTOMLBare(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, p.errorf("expected a key")
+			}
+			key = string(p.src[start:p.pos])
+		}
+		keys = append(keys, key)
+		p.skipSpaces()
+		if p.peek(0) != '.' {
+			return keys, nil
+		}
+		p.pos++
+		p.skipSpaces()
+	}
+}
+
+func (p *tomlParser) skipSpaces() {
+	for p.peek(0) == ' ' || p.peek(0) == '\t' {
+		p.pos++
+	}
+}
+
+func isTOMLBare(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	switch p.peek(0) {
+	case '"':
+		s, err := p.parseBasicString()
+		if err != nil {
+			return nil, err
+		}
+		return &flow.Node{Kind: flow.ScalarNode, Text: flowString(s)}, nil
+	case '\'':
+		s, err := p.parseLiteralString()
+		if err != nil {
+			return nil, err
+		}
+		return &flow.Node{Kind: flow.ScalarNode, Text: flowString(s)}, nil
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+	return p.parseLiteral()
+}
+
+func (p *tomlParser) parseArray() (*tomlArray, error) {
+	a := &tomlArray{}
+	p.pos++
+	for {
+		p.skipBlank()
+		if p.peek(0) == ']' {
+			p.pos++
+			return a, nil
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		a.elems = append(a.elems, v)
+		p.skipBlank()
+		switch p.peek(0) {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expected , or ] in an array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (*tomlTable, error) {
+	t := newTOMLTable()
+	p.pos++
+	p.skipSpaces()
+	if p.peek(0) == '}' {
+		p.pos++
+		t.defined, t.inline = true, true
+		return t, nil
+	}
+	for {
+		p.skipSpaces()
+		if err := p.parseKeyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		switch p.peek(0) {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			t.defined, t.inline = true, true
+			return t, nil
+		default:
+			return nil, p.errorf("expected , or } in an inline table")
+		}
+	}
+}
+
+var (
+	tomlDecimal  = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$`)
+	tomlPrefixed = regexp.MustCompile(`^0(x[0-9a-fA-F](_?[0-9a-fA-F])*|o[0-7](_?[0-7])*|b[01](_?[01])*)$`)
+	tomlFloat    = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$`)
+	tomlDateTime = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2}([Tt ][0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?([Zz]|[-+][0-9]{2}:[0-9]{2})?)?|[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?)$`)
+)
+
+// parseLiteral parses a boolean, a number or a date and time.
+func (p *tomlParser) parseLiteral() (*flow.Node, error) {
+	start := p.pos
+	for !p.eof() {
+		c := p.src[p.pos]
+		// a space may separate the date and the time.
+		if c == ' ' && p.pos-start == 10 && tomlDateTime.Match(p.src[start:p.pos]) &&
+			'0' <= p.peek(1) && p.peek(1) <= '9' {
+			p.pos++
+			continue
+		}
+		if !isTOMLBare(c) && strings.IndexByte("+.:", c) < 0 {
+			break
+		}
+		p.pos++
+	}
+	s := string(p.src[start:p.pos])
+	text := ""
+	switch {
+	case s == "true" || s == "false":
+		text = s
+	case s == "inf" || s == "+inf":
+		text = "+Inf"
+	case s == "-inf":
+		text = "-Inf"
+	case s == "nan" || s == "+nan" || s == "-nan":
+		text = "NaN"
+	case tomlDecimal.MatchString(s) || tomlFloat.MatchString(s):
+		text = strings.TrimPrefix(strings.Replace(s, "_", "", -1), "+")
+	case tomlPrefixed.MatchString(s):
+		text = strings.Replace(s, "_", "", -1)
+	case tomlDateTime.MatchString(s):
+		s = strings.Replace(strings.ToUpper(s), " ", "T", 1)
+		return &flow.Node{Kind: flow.TypeNode, Text: tomlDateTimeType,
+			Value: &flow.Node{Kind: flow.ScalarNode, Text: flowString(s)}}, nil
+	default:
+		p.pos = start
+		if s == "" {
+			return nil, p.errorf("expected a value")
+		}
+		return nil, p.errorf("invalid value %q", s)
+	}
+	return &flow.Node{Kind: flow.ScalarNode, Text: text}, nil
+}
+
+// parseBasicString parses a basic string or a multi-line basic string.
+func (p *tomlParser) parseBasicString() (string, error) {
+	multi := bytes.HasPrefix(p.src[p.pos:], []byte(`"""`))
+	if multi {
+		p.pos += 3
+		p.skipNewline()
+	} else {
+		p.pos++
+	}
+	var b strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '"' && !multi:
+			p.pos++
+			return b.String(), nil
+		case c == '"' && bytes.HasPrefix(p.src[p.pos:], []byte(`"""`)):
+			// up to two quotes may end the content.
+			for p.peek(3) == '"' {
+				b.WriteByte('"')
+				p.pos++
+			}
+			p.pos += 3
+			return b.String(), nil
+		case c == '\\':
+			if err := p.parseEscape(&b, multi); err != nil {
+				return "", err
+			}
+		case c == '\n' && !multi:
+			return "", p.errorf("unterminated string")
+		case c == '\r' && p.peek(1) == '\n':
+			p.pos++
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+var tomlEscapes = map[byte]string{
+	'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", '"': "\"", '\\': "\\",
+}
+
+// parseEscape parses an escape sequence of a basic string.
+func (p *tomlParser) parseEscape(b *strings.Builder, multi bool) error {
+	p.pos++
+	c := p.peek(0)
+	p.pos++
+	if s, ok := tomlEscapes[c]; ok {
+		b.WriteString(s)
+		return nil
+	}
+	n := 0
+	switch c {
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		// a line ending backslash trims the whitespace up to the next text.
+		p.pos--
+		start := p.pos
+		p.skipSpaces()
+		if multi && (p.peek(0) == '\n' || p.peek(0) == '\r') {
+			for strings.IndexByte(" \t\r\n", p.peek(0)) >= 0 && !p.eof() {
+				p.pos++
+			}
+			return nil
+		}
+		p.pos = start - 1
+		return p.errorf("invalid escape sequence")
+	}
+	if p.pos+n > len(p.src) {
+		return p.errorf("invalid escape sequence")
+	}
+	r, err := strconv.ParseUint(string(p.src[p.pos:p.pos+n]), 16, 32)
+	if err != nil {
+		return p.errorf("invalid escape sequence")
+	}
+	p.pos += n
+	b.WriteRune(rune(r))
+	return nil
+}
+
+// parseLiteralString parses a literal string or a multi-line literal
+// string.
+func (p *tomlParser) parseLiteralString() (string, error) {
+	if bytes.HasPrefix(p.src[p.pos:], []byte("'''")) {
+		p.pos += 3
+		p.skipNewline()
+		end := bytes.Index(p.src[p.pos:], []byte("'''"))
+		if end < 0 {
+			return "", p.errorf("unterminated string")
+		}
+		// up to two quotes may end the content.
+		for p.pos+end+3 < len(p.src) && p.src[p.pos+end+3] == '\'' {
+			end++
+		}
+		s := strings.Replace(string(p.src[p.pos:p.pos+end]), "\r\n", "\n", -1)
+		p.pos += end + 3
+		return s, nil
+	}
+	p.pos++
+	start := p.pos
+	for !p.eof() && p.src[p.pos] != '\'' {
+		if p.src[p.pos] == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	return string(p.src[start : p.pos-1]), nil
+}
+
+// skipNewline skips the line break right after the opening delimiter of a
+// multi-line string.
+func (p *tomlParser) skipNewline() {
+	switch {
+	case p.peek(0) == '\n':
+		p.pos++
+	case p.peek(0) == '\r' && p.peek(1) == '\n':
+		p.pos += 2
+	}
+}
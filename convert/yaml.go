@@ -0,0 +1,442 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ogdl/flow"
+)
+
+// YAMLToFlow converts a YAML document to an OGDL flow document in the
+// canonical layout of flow.Format.
+//
+// Plain scalars are resolved by the YAML 1.2 core schema. An anchor &name is
+// translated to the definition of a reference ^N, where N counts the anchors
+// from 1, and an alias *name to a use of it. A local tag !T is translated to
+// the type annotation !T and a secondary tag !!T other than those of the core
+// schema to !!T. A tag of the core schema is applied to the node, which must
+// be of its kind, and a scalar with a tag !!null, !!bool, !!int or !!float
+// must be a valid value of the type, an integer being converted to a float.
+func YAMLToFlow(src []byte) ([]byte, error) {
+	y, err := parseYAML(src)
+	if err != nil {
+		return nil, err
+	}
+	c := yamlConverter{ids: make(map[string]string)}
+	root, err := c.node(y)
+	if err != nil {
+		return nil, err
+	}
+	return writeFlow(root)
+}
+
+// yamlConverter translates a parsed YAML document to a document tree.
+type yamlConverter struct {
+	ids  map[string]string // reference IDs of the anchors
+	next int
+}
+
+func (c *yamlConverter) node(y *yamlNode) (*flow.Node, error) {
+	if y.kind == yamlAlias {
+		id, ok := c.ids[y.value]
+		if !ok {
+			return nil, fmt.Errorf("yaml: unknown anchor %q", y.value)
+		}
+		return &flow.Node{Kind: flow.RefNode, Text: id}, nil
+	}
+	var id string
+	if y.anchor != "" {
+		c.next++
+		id = strconv.Itoa(c.next)
+		c.ids[y.anchor] = id
+	}
+	if err := checkYAMLTag(y); err != nil {
+		return nil, err
+	}
+	n, err := c.value(y)
+	if err != nil {
+		return nil, err
+	}
+	if typ := flowType(y.tag); typ != "" {
+		n = &flow.Node{Kind: flow.TypeNode, Text: typ, Value: n}
+	}
+	if id != "" {
+		n = &flow.Node{Kind: flow.RefNode, Text: id, Value: n}
+	}
+	return n, nil
+}
+
+func (c *yamlConverter) value(y *yamlNode) (*flow.Node, error) {
+	switch y.kind {
+	case yamlSeq:
+		list := &flow.Node{Kind: flow.ListNode}
+		for _, item := range y.children {
+			n, err := c.node(item)
+			if err != nil {
+				return nil, err
+			}
+			list.Children = append(list.Children, n)
+		}
+		return list, nil
+	case yamlMap:
+		list := &flow.Node{Kind: flow.ListNode}
+		for i := 0; i < len(y.children); i += 2 {
+			key, err := flowYAMLKey(y.children[i])
+			if err != nil {
+				return nil, err
+			}
+			if key.Value, err = c.node(y.children[i+1]); err != nil {
+				return nil, err
+			}
+			list.Children = append(list.Children, key)
+		}
+		return list, nil
+	}
+	switch {
+	case yamlScalarTag(y.tag):
+		text, err := tagYAML(y.tag, y.value)
+		if err != nil {
+			return nil, err
+		}
+		return &flow.Node{Kind: flow.ScalarNode, Text: text}, nil
+	case y.plain && y.tag != "!!str":
+		if text, ok := resolveYAML(y.value); ok {
+			return &flow.Node{Kind: flow.ScalarNode, Text: text}, nil
+		}
+	}
+	return &flow.Node{Kind: flow.ScalarNode, Text: flowString(y.value)}, nil
+}
+
+func flowYAMLKey(y *yamlNode) (*flow.Node, error) {
+	if y.kind != yamlScalar || y.anchor != "" {
+		return nil, fmt.Errorf("yaml: a key that is not a scalar is not supported")
+	}
+	if err := checkYAMLTag(y); err != nil {
+		return nil, err
+	}
+	switch {
+	case yamlScalarTag(y.tag):
+		text, err := tagYAML(y.tag, y.value)
+		if err != nil {
+			return nil, err
+		}
+		return &flow.Node{Kind: flow.ScalarNode, Text: text}, nil
+	case y.plain && y.tag != "!!str":
+		if text, ok := resolveYAML(y.value); ok {
+			return &flow.Node{Kind: flow.ScalarNode, Text: text}, nil
+		}
+	}
+	return &flow.Node{Kind: flow.ScalarNode, Text: flowKey(y.value)}, nil
+}
+
+// yamlTagKinds are the kinds of the nodes that the tags of the core schema
+// apply to.
+var yamlTagKinds = map[string]yamlKind{
+	"!!str": yamlScalar, "!!null": yamlScalar, "!!bool": yamlScalar,
+	"!!int": yamlScalar, "!!float": yamlScalar, "!!map": yamlMap, "!!seq": yamlSeq,
+}
+
+var yamlKindNames = map[yamlKind]string{yamlScalar: "scalar", yamlSeq: "sequence", yamlMap: "mapping"}
+
+// checkYAMLTag returns an error if y has a tag of the core schema that does
+// not apply to its kind.
+func checkYAMLTag(y *yamlNode) error {
+	if kind, ok := yamlTagKinds[y.tag]; ok && kind != y.kind {
+		return fmt.Errorf("yaml: the tag %s cannot be applied to a %s", y.tag, yamlKindNames[y.kind])
+	}
+	return nil
+}
+
+// flowType returns the type annotation of a YAML tag, empty for the tags of
+// the core schema.
+func flowType(tag string) string {
+	switch tag {
+	case "", "!", "!!str", "!!int", "!!float", "!!bool", "!!null", "!!map", "!!seq":
+		return ""
+	}
+	return tag[1:]
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOct   = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolveYAML returns the OGDL flow scalar of a plain YAML scalar that is
+// not a string by the YAML 1.2 core schema, or false if it is a string.
+func resolveYAML(s string) (string, bool) {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return "nil", true
+	case "true", "True", "TRUE":
+		return "true", true
+	case "false", "False", "FALSE":
+		return "false", true
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return "+Inf", true
+	case "-.inf", "-.Inf", "-.INF":
+		return "-Inf", true
+	case ".nan", ".NaN", ".NAN":
+		return "NaN", true
+	}
+	switch {
+	case yamlInt.MatchString(s):
+		// unlike in Go, a leading 0 does not make an octal integer.
+		i, _ := new(big.Int).SetString(s, 10)
+		return i.String(), true
+	case yamlOct.MatchString(s), yamlHex.MatchString(s), yamlFloat.MatchString(s):
+		return s, true
+	}
+	return "", false
+}
+
+// yamlScalarTag reports whether tag is a tag of the core schema that resolves
+// a scalar to a type other than a string.
+func yamlScalarTag(tag string) bool {
+	switch tag {
+	case "!!null", "!!bool", "!!int", "!!float":
+		return true
+	}
+	return false
+}
+
+// tagYAML returns the OGDL flow scalar of a YAML scalar with the tag !!null,
+// !!bool, !!int or !!float, or an error if it is not a value of the type.
+func tagYAML(tag, s string) (string, error) {
+	text, ok := resolveYAML(s)
+	isInt := yamlInt.MatchString(s) || yamlOct.MatchString(s) || yamlHex.MatchString(s)
+	switch {
+	case !ok:
+	case tag == "!!null" && text == "nil",
+		tag == "!!bool" && (text == "true" || text == "false"),
+		tag == "!!int" && isInt:
+		return text, nil
+	case tag == "!!float" && isInt:
+		i, _ := new(big.Int).SetString(text, 0)
+		return i.String() + ".0", nil
+	case tag == "!!float" && (yamlFloat.MatchString(s) || text == "+Inf" || text == "-Inf" || text == "NaN"):
+		return text, nil
+	}
+	return "", fmt.Errorf("yaml: %q is not a valid %s", s, tag)
+}
+
+// FlowToYAML converts an OGDL flow document to a YAML document in block
+// style, the reverse of YAMLToFlow. A list of key value pairs is written as
+// a mapping and any other list as a sequence.
+func FlowToYAML(src []byte) ([]byte, error) {
+	root, err := parseFlow(src)
+	if err != nil {
+		return nil, err
+	}
+	var e yamlEmitter
+	if root == nil {
+		return []byte("null\n"), nil
+	}
+	if err := e.value(root, 0, yamlInDocument); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// the places where the value of a node is written.
+const (
+	yamlInDocument = iota
+	yamlInMapping  // after "key:"
+	yamlInSequence // after "-"
+)
+
+type yamlEmitter struct {
+	buf bytes.Buffer
+}
+
+// value writes the value chain n, the lines of a collection indented by
+// indent spaces.
+func (e *yamlEmitter) value(n *flow.Node, indent, place int) error {
+	id, typ, v, err := annotations(n)
+	if err != nil {
+		return err
+	}
+	var props []string
+	if id != "" {
+		props = append(props, "&"+id)
+	}
+	if typ != "" {
+		props = append(props, "!"+typ)
+	}
+	switch v.Kind {
+	case flow.RefNode:
+		e.inline(place, "*"+v.Text)
+		return nil
+	case flow.ScalarNode:
+		s, err := yamlText(v.Text)
+		if err != nil {
+			return err
+		}
+		e.inline(place, strings.Join(append(props, s), " "))
+		return nil
+	}
+	elems, pairs, err := elements(v)
+	if err != nil {
+		return err
+	}
+	if len(elems) == 0 {
+		e.inline(place, strings.Join(append(props, "[]"), " "))
+		return nil
+	}
+	// a collection in a sequence starts on the line of its "-".
+	compact := place == yamlInSequence && len(props) == 0
+	switch {
+	case compact:
+		e.buf.WriteByte(' ')
+	case len(props) > 0:
+		if place != yamlInDocument {
+			e.buf.WriteByte(' ')
+		}
+		e.buf.WriteString(strings.Join(props, " "))
+		e.buf.WriteByte('\n')
+	case place != yamlInDocument:
+		e.buf.WriteByte('\n')
+	}
+	for i, elem := range elems {
+		if i > 0 || !compact {
+			e.buf.WriteString(strings.Repeat(" ", indent))
+		}
+		if !pairs {
+			e.buf.WriteByte('-')
+			if err := e.value(elem, indent+2, yamlInSequence); err != nil {
+				return err
+			}
+			continue
+		}
+		key, err := yamlKey(elem)
+		if err != nil {
+			return err
+		}
+		e.buf.WriteString(key + ":")
+		if err := e.value(elem.Value, indent+2, yamlInMapping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inline writes a value that is not a collection.
+func (e *yamlEmitter) inline(place int, s string) {
+	if place != yamlInDocument {
+		e.buf.WriteByte(' ')
+	}
+	e.buf.WriteString(s)
+	e.buf.WriteByte('\n')
+}
+
+func yamlKey(pair *flow.Node) (string, error) {
+	s, err := keyString(pair)
+	if err != nil {
+		return "", err
+	}
+	if v, err := scalar(pair.Text); err == nil {
+		if _, ok := v.(string); !ok {
+			return yamlText(pair.Text)
+		}
+	}
+	return yamlString(s), nil
+}
+
+// yamlText returns the YAML scalar of an OGDL flow scalar.
+func yamlText(text string) (string, error) {
+	v, err := scalar(text)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case flow.Number:
+		return yamlNumber(v), nil
+	}
+	return yamlString(v.(string)), nil
+}
+
+// yamlNumber returns a numeral in the syntax of the YAML core schema, or a
+// quoted string for a complex number.
+func yamlNumber(num flow.Number) string {
+	s := string(num)
+	if i, ok := integer(num); ok {
+		if yamlHex.MatchString(s) || yamlOct.MatchString(s) {
+			return s
+		}
+		return i.String()
+	}
+	f, ok := float(num)
+	switch {
+	case !ok:
+		return yamlString(s)
+	case math.IsNaN(f):
+		return ".nan"
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	case yamlFloat.MatchString(s):
+		return s
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// yamlString returns s as a plain scalar if it is read back as the same
+// string, and as a double-quoted scalar otherwise.
+func yamlString(s string) string {
+	if _, ok := resolveYAML(s); ok || s != strings.TrimSpace(s) ||
+		strings.IndexByte("-?:,[]{}#&*!|>'\"%@`", s[0]) >= 0 || strings.HasPrefix(s, "...") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return yamlQuote(s)
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return yamlQuote(s)
+		}
+	}
+	return s
+}
+
+func yamlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x80 && !unicode.IsPrint(r):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case r < 0x10000 && !unicode.IsPrint(r) && r != ' ':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		case !unicode.IsPrint(r) && r != ' ':
+			fmt.Fprintf(&b, `\U%08x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
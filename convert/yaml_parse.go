@@ -0,0 +1,706 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlSeq
+	yamlMap
+	yamlAlias
+)
+
+// yamlNode is a node of a YAML document as parsed.
+type yamlNode struct {
+	kind     yamlKind
+	tag      string
+	anchor   string
+	value    string      // the text of a scalar or the name of an alias
+	plain    bool        // a scalar without quotes, resolved by its text
+	children []*yamlNode // the items of a sequence, or the keys and values of a mapping in turn
+}
+
+// yamlParser parses the block and flow styles of a single YAML document.
+// Complex keys with "?" and keys that are collections are not supported.
+type yamlParser struct {
+	src []byte
+	pos int
+}
+
+func parseYAML(src []byte) (*yamlNode, error) {
+	p := &yamlParser{src: src}
+	p.skipBlank()
+	for !p.eof() && p.src[p.pos] == '%' {
+		// directives
+		p.skipLine()
+		p.skipBlank()
+	}
+	if p.isDocMarker("---") {
+		p.pos += 3
+	}
+	n, err := p.parseNode(-1, false)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.isDocMarker("...") {
+		p.pos += 3
+		p.skipBlank()
+	}
+	switch {
+	case p.eof():
+		return n, nil
+	case p.isDocMarker("---"):
+		return nil, p.errorf("multiple documents are not supported")
+	}
+	return nil, p.errorf("unexpected %q", p.rest())
+}
+
+func (p *yamlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *yamlParser) peek(i int) byte {
+	if p.pos+i < len(p.src) {
+		return p.src[p.pos+i]
+	}
+	return 0
+}
+
+// col returns the 0-based column of the current position.
+func (p *yamlParser) col() int {
+	return p.pos - (bytes.LastIndexByte(p.src[:p.pos], '\n') + 1)
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := bytes.Count(p.src[:p.pos], []byte("\n")) + 1
+	return fmt.Errorf("yaml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// rest returns the rest of the current line.
+func (p *yamlParser) rest() string {
+	end := bytes.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		return string(p.src[p.pos:])
+	}
+	return strings.TrimRight(string(p.src[p.pos:p.pos+end]), "\r")
+}
+
+func (p *yamlParser) atEOL() bool {
+	return p.eof() || p.src[p.pos] == '\n' || p.src[p.pos] == '\r'
+}
+
+func (p *yamlParser) skipLine() {
+	for !p.atEOL() {
+		p.pos++
+	}
+}
+
+// skipInline skips spaces and a comment up to the end of the line.
+func (p *yamlParser) skipInline() {
+	for !p.eof() {
+		switch p.src[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '#':
+			p.skipLine()
+			return
+		default:
+			return
+		}
+	}
+}
+
+// skipBlank skips spaces, comments and line breaks.
+func (p *yamlParser) skipBlank() {
+	for {
+		p.skipInline()
+		if p.eof() || p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *yamlParser) isDocMarker(marker string) bool {
+	return p.col() == 0 && bytes.HasPrefix(p.src[p.pos:], []byte(marker)) && isBlankAt(p.src, p.pos+3)
+}
+
+// isBlankAt reports whether src[i] is a space or a line break, or i is at
+// the end of src.
+func isBlankAt(src []byte, i int) bool {
+	return i >= len(src) || strings.IndexByte(" \t\r\n", src[i]) >= 0
+}
+
+func isFlowIndicator(c byte) bool {
+	return strings.IndexByte(",[]{}", c) >= 0
+}
+
+func (p *yamlParser) isSeqEntry() bool {
+	return p.peek(0) == '-' && isBlankAt(p.src, p.pos+1)
+}
+
+// isMapKey reports whether the current line starts with a key of a block
+// mapping.
+func (p *yamlParser) isMapKey() bool {
+	i := p.pos
+	if i >= len(p.src) {
+		return false
+	}
+	switch c := p.src[i]; c {
+	case '"', '\'':
+		for i++; i < len(p.src) && p.src[i] != c && p.src[i] != '\n'; i++ {
+			if c == '"' && p.src[i] == '\\' {
+				i++
+			}
+		}
+		for i++; i < len(p.src) && (p.src[i] == ' ' || p.src[i] == '\t'); i++ {
+		}
+		return i < len(p.src) && p.src[i] == ':' && isBlankAt(p.src, i+1)
+	case '[', '{', '&', '!', '*', '|', '>', '#', '?':
+		return false
+	}
+	for ; i < len(p.src) && p.src[i] != '\n'; i++ {
+		switch {
+		case p.src[i] == ':' && isBlankAt(p.src, i+1):
+			return true
+		case p.src[i] == '#' && (p.src[i-1] == ' ' || p.src[i-1] == '\t'):
+			return false
+		}
+	}
+	return false
+}
+
+// parseNode parses the node that starts at the current position, or on the
+// following lines if the current line is empty. The lines of a block node
+// must be indented by more than indent, except for a sequence that is the
+// value of a mapping if seqAtIndent is true.
+func (p *yamlParser) parseNode(indent int, seqAtIndent bool) (*yamlNode, error) {
+	p.skipInline()
+	var anchor, tag string
+	for !p.atEOL() && (p.src[p.pos] == '&' || p.src[p.pos] == '!') {
+		name := p.readName()
+		if name[0] == '&' {
+			anchor = name[1:]
+		} else {
+			tag = name
+		}
+		p.skipInline()
+	}
+	var (
+		n   *yamlNode
+		err error
+	)
+	if p.atEOL() {
+		p.skipBlank()
+		col := p.col()
+		switch {
+		case p.eof() || p.isDocMarker("---") || p.isDocMarker("..."):
+		case col > indent || col == indent && seqAtIndent && p.isSeqEntry():
+			n, err = p.parseBlock(indent)
+		}
+		if n == nil && err == nil {
+			n = &yamlNode{kind: yamlScalar, plain: true}
+		}
+	} else {
+		n, err = p.parseBlock(indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" || tag != "" {
+		if n.kind == yamlAlias {
+			return nil, p.errorf("an alias cannot have an anchor or a tag")
+		}
+		n.anchor, n.tag = anchor, tag
+	}
+	return n, nil
+}
+
+// readName reads an anchor, an alias or a tag, including its indicator.
+func (p *yamlParser) readName() string {
+	start := p.pos
+	for p.pos++; !isBlankAt(p.src, p.pos) && !isFlowIndicator(p.src[p.pos]); p.pos++ {
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *yamlParser) parseBlock(indent int) (*yamlNode, error) {
+	switch col := p.col(); {
+	case p.isSeqEntry():
+		return p.parseSeq(col)
+	case p.isMapKey():
+		return p.parseMap(col)
+	}
+	var (
+		n   *yamlNode
+		err error
+	)
+	switch p.src[p.pos] {
+	case '|', '>':
+		return p.parseBlockScalar(indent)
+	case '*':
+		n = &yamlNode{kind: yamlAlias, value: p.readName()[1:]}
+	case '[', '{':
+		n, err = p.parseFlow()
+	case '"':
+		n, err = p.parseDoubleQuoted()
+	case '\'':
+		n, err = p.parseSingleQuoted()
+	default:
+		return p.parsePlain(indent, false), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.skipInline()
+	if !p.atEOL() {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseSeq(col int) (*yamlNode, error) {
+	seq := &yamlNode{kind: yamlSeq}
+	for {
+		p.pos++
+		item, err := p.parseNode(col, false)
+		if err != nil {
+			return nil, err
+		}
+		seq.children = append(seq.children, item)
+		p.skipBlank()
+		if p.eof() || p.isDocMarker("---") || p.isDocMarker("...") || p.col() < col {
+			return seq, nil
+		}
+		if p.col() > col {
+			return nil, p.errorf("bad indentation of a sequence entry")
+		}
+		if !p.isSeqEntry() {
+			return seq, nil
+		}
+	}
+}
+
+func (p *yamlParser) parseMap(col int) (*yamlNode, error) {
+	m := &yamlNode{kind: yamlMap}
+	for {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.parseNode(col, true)
+		if err != nil {
+			return nil, err
+		}
+		m.children = append(m.children, key, value)
+		p.skipBlank()
+		if p.eof() || p.isDocMarker("---") || p.isDocMarker("...") || p.col() < col {
+			return m, nil
+		}
+		if p.col() > col || !p.isMapKey() {
+			return nil, p.errorf("bad indentation of a mapping entry")
+		}
+	}
+}
+
+// parseKey parses a key of a block mapping and the ":" after it.
+func (p *yamlParser) parseKey() (*yamlNode, error) {
+	var (
+		key *yamlNode
+		err error
+	)
+	switch p.src[p.pos] {
+	case '"':
+		key, err = p.parseDoubleQuoted()
+	case '\'':
+		key, err = p.parseSingleQuoted()
+	default:
+		start := p.pos
+		for p.src[p.pos] != ':' || !isBlankAt(p.src, p.pos+1) {
+			p.pos++
+		}
+		key = &yamlNode{kind: yamlScalar, plain: true,
+			value: strings.TrimRight(string(p.src[start:p.pos]), " \t")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(0) == ' ' || p.peek(0) == '\t' {
+		p.pos++
+	}
+	if p.peek(0) != ':' {
+		return nil, p.errorf("expected ':' after a mapping key")
+	}
+	p.pos++
+	return key, nil
+}
+
+// parsePlain parses a plain scalar, which continues on the following lines
+// that are indented by more than indent.
+func (p *yamlParser) parsePlain(indent int, flow bool) *yamlNode {
+	var b strings.Builder
+	for {
+		start := p.pos
+	line:
+		for ; !p.atEOL(); p.pos++ {
+			switch c := p.src[p.pos]; {
+			case c == ':' && (isBlankAt(p.src, p.pos+1) || flow && isFlowIndicator(p.peek(1))):
+				break line
+			case c == '#' && p.pos > start && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t'):
+				break line
+			case flow && isFlowIndicator(c):
+				break line
+			}
+		}
+		b.WriteString(strings.TrimRight(string(p.src[start:p.pos]), " \t"))
+		if !p.atEOL() {
+			break
+		}
+		// look for a continuation line.
+		q, breaks := p.pos, 0
+		for ; q < len(p.src) && strings.IndexByte(" \t\r\n", p.src[q]) >= 0; q++ {
+			if p.src[q] == '\n' {
+				breaks++
+			}
+		}
+		if q == len(p.src) || p.src[q] == '#' {
+			break
+		}
+		save := p.pos
+		p.pos = q
+		if flow && isFlowIndicator(p.src[q]) || p.isDocMarker("---") || p.isDocMarker("...") ||
+			!flow && (p.col() <= indent || p.isMapKey()) {
+			p.pos = save
+			break
+		}
+		if breaks == 1 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(strings.Repeat("\n", breaks-1))
+		}
+	}
+	return &yamlNode{kind: yamlScalar, plain: true, value: b.String()}
+}
+
+func (p *yamlParser) parseDoubleQuoted() (*yamlNode, error) {
+	var b strings.Builder
+	for p.pos++; ; {
+		if p.eof() {
+			return nil, p.errorf("unterminated double-quoted scalar")
+		}
+		switch c := p.src[p.pos]; c {
+		case '"':
+			p.pos++
+			return &yamlNode{kind: yamlScalar, value: b.String()}, nil
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return nil, err
+			}
+		case '\n', '\r':
+			p.fold(&b)
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"",
+	'/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028",
+	'P': "\u2029",
+}
+
+// parseEscape parses an escape sequence of a double-quoted scalar.
+func (p *yamlParser) parseEscape(b *strings.Builder) error {
+	p.pos++
+	c := p.peek(0)
+	p.pos++
+	if s, ok := yamlEscapes[c]; ok {
+		b.WriteString(s)
+		return nil
+	}
+	n := 0
+	switch c {
+	case '\n', '\r':
+		// an escaped line break is removed with the indentation after it.
+		if c == '\r' && p.peek(0) == '\n' {
+			p.pos++
+		}
+		for p.peek(0) == ' ' || p.peek(0) == '\t' {
+			p.pos++
+		}
+		return nil
+	case 'x':
+		n = 2
+	case 'u':
+		n = 4
+	case 'U':
+		n = 8
+	default:
+		p.pos -= 2
+		return p.errorf("invalid escape sequence")
+	}
+	if p.pos+n > len(p.src) {
+		return p.errorf("invalid escape sequence")
+	}
+	r, err := strconv.ParseUint(string(p.src[p.pos:p.pos+n]), 16, 32)
+	if err != nil {
+		return p.errorf("invalid escape sequence")
+	}
+	p.pos += n
+	b.WriteRune(rune(r))
+	return nil
+}
+
+func (p *yamlParser) parseSingleQuoted() (*yamlNode, error) {
+	var b strings.Builder
+	for p.pos++; ; {
+		if p.eof() {
+			return nil, p.errorf("unterminated single-quoted scalar")
+		}
+		switch c := p.src[p.pos]; {
+		case c == '\'' && p.peek(1) == '\'':
+			b.WriteByte('\'')
+			p.pos += 2
+		case c == '\'':
+			p.pos++
+			return &yamlNode{kind: yamlScalar, value: b.String()}, nil
+		case c == '\n' || c == '\r':
+			p.fold(&b)
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// fold folds the line breaks of a quoted scalar into a space, or into n-1
+// newlines for n line breaks, removing the white space around them.
+func (p *yamlParser) fold(b *strings.Builder) {
+	s := strings.TrimRight(b.String(), " \t")
+	b.Reset()
+	b.WriteString(s)
+	breaks := 0
+	for ; !p.eof() && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0; p.pos++ {
+		if p.src[p.pos] == '\n' {
+			breaks++
+		}
+	}
+	if breaks == 1 {
+		b.WriteByte(' ')
+	} else {
+		b.WriteString(strings.Repeat("\n", breaks-1))
+	}
+}
+
+// parseBlockScalar parses a literal or folded block scalar whose lines are
+// indented by more than indent.
+func (p *yamlParser) parseBlockScalar(indent int) (*yamlNode, error) {
+	literal := p.src[p.pos] == '|'
+	var chomp byte
+	blockIndent := -1
+	for p.pos++; ; p.pos++ {
+		if c := p.peek(0); c == '+' || c == '-' {
+			chomp = c
+		} else if '1' <= c && c <= '9' {
+			if indent < 0 {
+				indent = 0
+			}
+			blockIndent = indent + int(c-'0')
+		} else {
+			break
+		}
+	}
+	p.skipInline()
+	if !p.atEOL() {
+		return nil, p.errorf("unexpected %q after a block scalar indicator", p.rest())
+	}
+	var lines []string
+	for p.skipLineBreak(); !p.eof(); p.skipLineBreak() {
+		start := p.pos
+		for p.peek(0) == ' ' {
+			p.pos++
+		}
+		if p.atEOL() {
+			if blockIndent >= 0 && p.pos-start > blockIndent {
+				lines = append(lines, string(p.src[start+blockIndent:p.pos]))
+			} else {
+				lines = append(lines, "")
+			}
+			continue
+		}
+		if blockIndent < 0 && p.pos-start > indent {
+			blockIndent = p.pos - start
+		}
+		if p.pos-start < blockIndent || blockIndent < 0 {
+			p.pos = start
+			break
+		}
+		p.pos = start + blockIndent
+		lineStart := p.pos
+		p.skipLine()
+		lines = append(lines, string(p.src[lineStart:p.pos]))
+	}
+	trailing := 0
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	body := lines[:len(lines)-trailing]
+	var s string
+	if literal {
+		s = strings.Join(body, "\n")
+	} else {
+		s = foldLines(body)
+	}
+	switch {
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing)
+		if len(body) > 0 {
+			s += "\n"
+		}
+	case chomp != '-' && len(body) > 0:
+		s += "\n"
+	}
+	return &yamlNode{kind: yamlScalar, value: s}, nil
+}
+
+func (p *yamlParser) skipLineBreak() {
+	if p.peek(0) == '\r' {
+		p.pos++
+	}
+	if p.peek(0) == '\n' {
+		p.pos++
+	}
+}
+
+// foldLines joins the lines of a folded block scalar: a line break between
+// two lines is folded into a space unless there are empty lines between
+// them or one of them is more indented.
+func foldLines(lines []string) string {
+	var b strings.Builder
+	empty := 0
+	prev := -1
+	for i, line := range lines {
+		if line == "" {
+			empty++
+			continue
+		}
+		if prev >= 0 {
+			more := lines[prev][0] == ' ' || lines[prev][0] == '\t' || line[0] == ' ' || line[0] == '\t'
+			switch {
+			case empty == 0 && !more:
+				b.WriteByte(' ')
+			case more:
+				b.WriteString(strings.Repeat("\n", empty+1))
+			default:
+				b.WriteString(strings.Repeat("\n", empty))
+			}
+		} else {
+			b.WriteString(strings.Repeat("\n", empty))
+		}
+		b.WriteString(line)
+		empty = 0
+		prev = i
+	}
+	return b.String()
+}
+
+// parseFlow parses a flow sequence or mapping.
+func (p *yamlParser) parseFlow() (*yamlNode, error) {
+	n := &yamlNode{kind: yamlSeq}
+	end := byte(']')
+	if p.src[p.pos] == '{' {
+		n.kind, end = yamlMap, '}'
+	}
+	p.pos++
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil, p.errorf("unterminated flow collection")
+		}
+		if p.src[p.pos] == end {
+			p.pos++
+			return n, nil
+		}
+		item, err := p.parseFlowNode()
+		if err != nil {
+			return nil, err
+		}
+		p.skipBlank()
+		switch {
+		case p.peek(0) == ':':
+			p.pos++
+			p.skipBlank()
+			value := &yamlNode{kind: yamlScalar, plain: true}
+			if c := p.peek(0); c != ',' && c != end {
+				if value, err = p.parseFlowNode(); err != nil {
+					return nil, err
+				}
+			}
+			if n.kind == yamlMap {
+				n.children = append(n.children, item, value)
+			} else {
+				n.children = append(n.children, &yamlNode{kind: yamlMap, children: []*yamlNode{item, value}})
+			}
+		case n.kind == yamlMap:
+			n.children = append(n.children, item, &yamlNode{kind: yamlScalar, plain: true})
+		default:
+			n.children = append(n.children, item)
+		}
+		p.skipBlank()
+		switch p.peek(0) {
+		case ',':
+			p.pos++
+		case end:
+		default:
+			return nil, p.errorf("expected ',' or '%c' in a flow collection", end)
+		}
+	}
+}
+
+func (p *yamlParser) parseFlowNode() (*yamlNode, error) {
+	var anchor, tag string
+	for c := p.peek(0); c == '&' || c == '!'; c = p.peek(0) {
+		name := p.readName()
+		if name[0] == '&' {
+			anchor = name[1:]
+		} else {
+			tag = name
+		}
+		p.skipBlank()
+	}
+	var (
+		n   *yamlNode
+		err error
+	)
+	switch p.peek(0) {
+	case '[', '{':
+		n, err = p.parseFlow()
+	case '*':
+		n = &yamlNode{kind: yamlAlias, value: p.readName()[1:]}
+	case '"':
+		n, err = p.parseDoubleQuoted()
+	case '\'':
+		n, err = p.parseSingleQuoted()
+	default:
+		n = p.parsePlain(-1, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.anchor, n.tag = anchor, tag
+	return n, nil
+}
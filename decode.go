@@ -5,31 +5,64 @@
 package flow
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"reflect"
 )
 
+// Unmarshal parses the OGDL flow encoded data and stores the result in the
+// value pointed to by v. The data must contain exactly one root value.
+func Unmarshal(data []byte, v interface{}) error {
+	dec := NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return dec.error()
+		}
+		return err
+	}
+	if err := dec.advance(); err != nil {
+		return err
+	}
+	if !dec.isEOF() {
+		return dec.error()
+	}
+	return nil
+}
+
+// A Decoder reads and decodes successive OGDL flow values, each a document
+// of its own, from an input stream.
 type Decoder struct {
 	*parser
 	refSetter
+	pending bool // the current token has been decoded
+	depth   int
+	lists   int // lists started by Token and not yet ended
 }
 
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{
-		newParser(r),
-		newRefSetter(),
+		parser:    newParser(r),
+		refSetter: newRefSetter(),
+		pending:   true,
 	}
 }
 
+// Decode reads the next value from its input and stores it in the value
+// pointed to by v. References are resolved within the value. At the end of
+// input, Decode returns io.EOF.
 func (dec *Decoder) Decode(v interface{}) error {
 	rv, ok := v.(reflect.Value)
 	if !ok {
 		rv = reflect.ValueOf(v)
 	}
-	if err := dec.next(); err != nil {
+	if err := dec.advance(); err != nil {
 		return err
 	}
+	if dec.isEOF() {
+		return io.EOF
+	}
+	dec.refSetter = newRefSetter()
 	if err := dec.ParseAny(rv); err != nil {
 		return err
 	}
@@ -37,7 +70,49 @@ func (dec *Decoder) Decode(v interface{}) error {
 	return nil
 }
 
+// More reports whether there is another value in the input, or in the
+// current list if one has been started by Token. It also returns true on an
+// input error, which is then returned by Decode.
+func (dec *Decoder) More() bool {
+	return dec.advance() != nil || !dec.isEOF() && !(dec.lists > 0 && dec.isListEnd())
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer,
+// which is valid until the next call to Decode or More.
+func (dec *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(dec.buffered(!dec.pending))
+}
+
+// InputOffset returns the byte offset in the input right after the last
+// decoded value, or of the next value once More has been called.
+func (dec *Decoder) InputOffset() int64 {
+	if dec.pending {
+		return int64(dec.end.offset)
+	}
+	return int64(dec.pos.offset)
+}
+
+// advance reads the token after the last decoded value, which is delayed
+// so that Decode does not block on input beyond the value.
+func (dec *Decoder) advance() error {
+	if !dec.pending {
+		return nil
+	}
+	dec.pending = false
+	if err := dec.next(); err != nil {
+		return err
+	}
+	if dec.lists > 0 && dec.isSep() {
+		return dec.next()
+	}
+	return nil
+}
+
 func (dec *Decoder) ParseAny(v reflect.Value) (err error) {
+	dec.depth++
+	defer func() {
+		dec.depth--
+	}()
 	if !v.CanSet() && v.Kind() != reflect.Ptr { // TODO: interface should also be allowed.
 		return fmt.Errorf("unsetable nonpointer value: %v", v)
 	}
@@ -46,18 +121,23 @@ func (dec *Decoder) ParseAny(v reflect.Value) (err error) {
 	}
 	for dec.isRef() || dec.isType() {
 		if dec.isRef() {
-			id := string(dec.Token().Value[1:])
+			id := string(dec.current().Value[1:])
 			if err := dec.next(); err != nil {
 				return err
 			}
 			if dec.isSepOrListEnd() {
 				dec.addDstRef(id, v)
+				if src := dec.m[id].src; v.Kind() == reflect.Interface && src.IsValid() {
+					// an untyped value may be copied out of v before
+					// setAllRef, so take the value defined so far.
+					v.Set(src)
+				}
 				return nil
 			} else {
 				dec.addSrcRef(id, v)
 			}
 		} else if dec.isType() {
-			typ := string(dec.Token().Value[1:])
+			typ := string(dec.current().Value[1:])
 			t, ok := nameToType[typ]
 			if !ok {
 				return fmt.Errorf("type %s is not registered.", typ)
@@ -75,13 +155,31 @@ func (dec *Decoder) ParseAny(v reflect.Value) (err error) {
 	}
 
 	defer func() {
+		if dec.depth == 1 {
+			dec.pending = true
+			return
+		}
 		if e := dec.next(); e != nil && e != io.EOF {
 			err = e
 		}
 	}()
+	if dec.setBinaryScalar(v) {
+		return nil
+	}
+	pos := dec.pos
 	for _, match := range matchFuncs {
 		if encoding, ok := match(v); ok && encoding.Decode != nil {
-			return encoding.Decode(dec)
+			err := encoding.Decode(dec)
+			if de, ok := err.(decodeError); ok && de.base().Line == 0 {
+				e := de.base()
+				e.Offset, e.Line, e.Column = int64(pos.offset), pos.line, pos.column
+			} else if se, ok := err.(*SyntaxError); ok && se.Line == 0 {
+				// a SyntaxError from a value decoder is located relative to
+				// the start of the value.
+				se.Offset += int64(pos.offset)
+				se.Line, se.Column = pos.line, pos.column+se.Column
+			}
+			return err
 		}
 	}
 	return fmt.Errorf("no decoding method defined for type: %v", v.Type())
@@ -5,9 +5,11 @@
 package flow
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 )
 
 func Marshal(v interface{}) ([]byte, error) {
@@ -15,7 +17,7 @@ func Marshal(v interface{}) ([]byte, error) {
 	if err := enc.marshal(v); err != nil {
 		return nil, err
 	}
-	return enc.Bytes(), nil
+	return enc.doc.Bytes(), nil
 }
 
 // MarshalIndent is like Marshal but applies Indent to format the output.
@@ -24,19 +26,94 @@ func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
 	if err := enc.marshalIndent(v, prefix, indent); err != nil {
 		return nil, err
 	}
-	return enc.Bytes(), nil
+	return enc.doc.Bytes(), nil
 }
 
+// An Encoder writes OGDL flow values to an output stream, separating
+// successive values with a newline.
 type Encoder struct {
-	w io.Writer
 	refDetector
 	composer
+	out   io.Writer
+	doc   bytes.Buffer // the value being encoded, written to out if complete
+	werr  error        // error writing to out
+	count int          // values encoded
+	keys  *keyEncoder  // created by the first map
+}
+
+// keyEncoder encodes the keys of maps into one reused buffer. With nested
+// alignment, it keeps the sorted keys of each map of the value being
+// encoded, so that each key is encoded once although it is also measured.
+type keyEncoder struct {
+	buf    bytes.Buffer
+	enc    *Encoder
+	sorted map[uintptr]sortedMap
+}
+
+// sortedMap holds the map that its keys belong to, so that the address of
+// the map is not reused while the keys are kept.
+type sortedMap struct {
+	m    reflect.Value
+	keys mapKeys
 }
 
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{
-		w:           w,
-		refDetector: newRefDetector()}
+	enc := &Encoder{
+		refDetector: newRefDetector(),
+		out:         w,
+	}
+	enc.composer = newComposer(&enc.doc)
+	return enc
+}
+
+// SetIndent makes the Encoder write each element of a list on its own line,
+// beginning with prefix followed by one copy of indent per nesting level,
+// like MarshalIndent. SetIndent("", "") turns indentation off.
+func (enc *Encoder) SetIndent(prefix, indent string) {
+	enc.indented = prefix != "" || indent != ""
+	enc.prefix, enc.indent = prefix, indent
+}
+
+// SetTrailingComma sets whether the last element of a list written over
+// several lines is followed by a comma, which is the default.
+func (enc *Encoder) SetTrailingComma(on bool) {
+	enc.noTrailingComma = !on
+}
+
+// SetCompactSeparator sets whether the elements of a list written on one
+// line are separated by "," instead of ", ".
+func (enc *Encoder) SetCompactSeparator(on bool) {
+	enc.compactSep = on
+}
+
+// SetMaxWidth sets the line width, in bytes, within which an indented list
+// is written on one line, 80 by default. With a width of 0, every non-empty
+// list is broken over lines.
+func (enc *Encoder) SetMaxWidth(width int) {
+	enc.maxWidth = width
+}
+
+// SetAlignNested sets whether the values of the structs and maps in an
+// indented list are aligned with each other instead of within each of them.
+func (enc *Encoder) SetAlignNested(on bool) {
+	enc.alignNested = on
+}
+
+// SetHexUint sets whether unsigned integers are written in hexadecimal with
+// a 0x prefix, which suits bit masks and file modes.
+func (enc *Encoder) SetHexUint(on bool) {
+	enc.opts.hexUint = on
+}
+
+// SetFloatFormat sets the format and precision of floating point and
+// complex values, with the same meaning as in strconv.FormatFloat, e.g.
+// ('f', 2) for two digits after the decimal point. The default is ('g', -1),
+// the shortest representation that decodes to the same value. The 'b'
+// format is not decodable. NaN and infinities are always written as NaN,
+// +Inf and -Inf.
+func (enc *Encoder) SetFloatFormat(format byte, prec int) {
+	enc.opts.floatFormat = format
+	enc.opts.floatPrec = prec
 }
 
 func (enc *Encoder) marshal(v interface{}) error {
@@ -44,7 +121,7 @@ func (enc *Encoder) marshal(v interface{}) error {
 	if err := enc.ComposeAny(reflect.ValueOf(v)); err != nil {
 		return err
 	}
-	return nil
+	return enc.flush()
 }
 
 func (enc *Encoder) marshalIndent(v interface{}, prefix, indent string) error {
@@ -55,26 +132,57 @@ func (enc *Encoder) marshalIndent(v interface{}, prefix, indent string) error {
 	return enc.marshal(v)
 }
 
+// Encode writes v to the output stream, preceded by a newline if it is not
+// the first value. References are detected within v only. Nothing is
+// written if v cannot be encoded. If writing fails, the error is returned by
+// this and every later call.
 func (enc *Encoder) Encode(v interface{}) error {
+	if enc.werr != nil {
+		return enc.werr
+	}
 	rv, ok := v.(reflect.Value)
 	if !ok {
 		rv = reflect.ValueOf(v)
 	}
+	enc.refDetector = newRefDetector()
+	if enc.keys != nil {
+		enc.keys.sorted = nil
+	}
 	enc.populate(rv)
 	if enc.serial > 1 {
 		if rv.Kind() != reflect.Ptr && !rv.CanAddr() {
 			return fmt.Errorf("object with cyclic reference must be addressable, %v", v)
 		}
 	}
+	enc.doc.Reset()
+	enc.w.Reset(&enc.doc)
+	enc.err = nil
+	enc.text = enc.text[:0]
+	enc.reset()
+	if enc.count > 0 && !enc.binary {
+		enc.writeRaw("\n")
+	}
+	if enc.indented {
+		enc.writeRaw(enc.prefix)
+	}
 	enc.encodeRootType(rv)
-	if err := enc.ComposeAny(rv); err != nil {
+	err := enc.ComposeAny(rv)
+	if ferr := enc.flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
 		return err
 	}
-	_, err := enc.w.Write(enc.Bytes())
-	return err
+	enc.count++
+	if _, err := enc.out.Write(enc.doc.Bytes()); err != nil {
+		enc.werr = err
+		return err
+	}
+	return nil
 }
 
-// encode never returns an error, it may panics with bytes.ErrTooLarge.
+// ComposeAny writes v through the buffer of the Encoder, write errors are
+// returned when the buffer is flushed.
 func (enc *Encoder) ComposeAny(v reflect.Value) error {
 	if !v.IsValid() {
 		enc.encodeNil()
@@ -85,9 +193,13 @@ func (enc *Encoder) ComposeAny(v reflect.Value) error {
 		id := enc.getPtrID(key)
 		if id > 0 && !enc.m[key].defined {
 			enc.define(key)
-			enc.WriteString(fmt.Sprintf("^%d ", id))
+			enc.writeAnnotation(fmt.Sprintf("^%d", id))
 		}
 	}
+	if enc.binary && isTypedScalar(v.Type()) {
+		// as by the Encoding of matchValue, without making it.
+		return typeToValueEncoding[v.Kind()].Encode(v, enc)
+	}
 	for _, match := range matchFuncs {
 		if encoding, ok := match(v); ok && encoding.Encode != nil {
 			return encoding.Encode(enc)
@@ -104,6 +216,58 @@ func (enc *Encoder) ComposeAny(v reflect.Value) error {
 	return nil
 }
 
+// encodeKey returns the text of a map key, encoded with the options of enc.
+func (enc *Encoder) encodeKey(v reflect.Value) (string, error) {
+	if enc.keys == nil {
+		enc.keys = &keyEncoder{}
+		enc.keys.enc = NewEncoder(&enc.keys.buf)
+	}
+	k := enc.keys
+	k.buf.Reset()
+	k.enc.count = 0
+	k.enc.opts = enc.opts
+	if err := k.enc.Encode(v); err != nil {
+		return "", err
+	}
+	return k.buf.String(), nil
+}
+
+// sortedKeys returns the keys of a map value, sorted and encoded with the
+// options of enc.
+func (enc *Encoder) sortedKeys(v reflect.Value) (mapKeys, error) {
+	if v.Len() == 0 {
+		return nil, nil
+	}
+	if enc.keys != nil {
+		if sm, ok := enc.keys.sorted[v.Pointer()]; ok {
+			return sm.keys, nil
+		}
+	}
+	keys := make(mapKeys, v.Len())
+	// typed keys of the binary form are written and ordered without text.
+	typed := enc.binary && isTypedKey(v.Type().Key())
+	for i, key := range v.MapKeys() {
+		if typed {
+			keys[i] = mapKey{value: key}
+			continue
+		}
+		text, err := enc.encodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = mapKey{key, text}
+	}
+	sort.Sort(keys)
+	if enc.alignNested && enc.indented {
+		// the keys are measured before the map is written.
+		if enc.keys.sorted == nil {
+			enc.keys.sorted = make(map[uintptr]sortedMap)
+		}
+		enc.keys.sorted[v.Pointer()] = sortedMap{v, keys}
+	}
+	return keys, nil
+}
+
 func (enc *Encoder) encodePtr(v reflect.Value) {
 	if v.IsNil() {
 		enc.encodeNil()
@@ -116,7 +280,7 @@ func (enc *Encoder) encodePtr(v reflect.Value) {
 			enc.WriteString(fmt.Sprintf("^%d", id))
 		} else {
 			enc.define(key)
-			enc.WriteString(fmt.Sprintf("^%d ", id))
+			enc.writeAnnotation(fmt.Sprintf("^%d", id))
 			enc.ComposeAny(v.Elem())
 		}
 	} else {
@@ -130,7 +294,9 @@ func (enc *Encoder) encodeInterface(v reflect.Value) {
 		return
 	}
 	v = v.Elem()
-	enc.encodeType(v)
+	if !isGenericType(v.Type()) {
+		enc.encodeType(v)
+	}
 	enc.ComposeAny(v)
 }
 
@@ -141,7 +307,7 @@ func (enc *Encoder) encodeRootType(v reflect.Value) {
 		reflect.Uint32, reflect.Uint64, reflect.Uint, reflect.Uintptr,
 		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
 		reflect.String:
-		if v.Kind().String() != v.Type().Name() {
+		if v.Kind().String() != v.Type().Name() && !isGenericType(v.Type()) {
 			enc.encodeType(v)
 		}
 	}
@@ -149,7 +315,7 @@ func (enc *Encoder) encodeRootType(v reflect.Value) {
 
 func (enc *Encoder) encodeType(v reflect.Value) {
 	typ := indirectType(v.Type()).Name()
-	composeValue(enc, "!"+typ+" ")
+	enc.writeAnnotation("!" + typ)
 }
 
 func indirectType(t reflect.Type) reflect.Type {
@@ -223,8 +389,10 @@ func (d *refDetector) populate(v reflect.Value) {
 			d.populate(v.Index(i))
 		}
 	case reflect.Struct:
-		for i := 0; i < v.Type().NumField(); i++ {
-			d.populate(v.Field(i))
+		for _, f := range cachedFields(v.Type()) {
+			if fv, ok := fieldByIndex(v, f.index); ok {
+				d.populate(fv)
+			}
 		}
 	case reflect.Map:
 		for _, k := range v.MapKeys() {
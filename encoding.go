@@ -7,6 +7,8 @@ package flow
 import (
 	"bytes"
 	"encoding"
+	"io"
+	"math"
 	"reflect"
 	"sort"
 	"strings"
@@ -42,13 +44,16 @@ var (
 
 func init() {
 	matchFuncs = []MatchFunc{
+		matchNumber,
 		matchValue,
 		matchMarshaler,
 		matchTextMarshaler,
 		matchStruct,
+		matchGenericMap,
 		matchSlice,
 		matchMap,
 		matchArray,
+		matchInterface,
 	}
 }
 
@@ -139,8 +144,16 @@ func matchArray(v reflect.Value) (*Encoding, bool) {
 
 func encodeArray(v reflect.Value) EncodeFunc {
 	return func(c Composer) error {
-		return c.ComposeList(v.Len(), func(i int) error {
-			return c.ComposeAny(v.Index(i))
+		elems := make([]reflect.Value, v.Len())
+		for i := range elems {
+			elems[i] = v.Index(i)
+		}
+		width, err := nestedKeyWidth(c, elems)
+		if err != nil {
+			return err
+		}
+		return c.ComposeList(len(elems), func(i int) error {
+			return composeAligned(c, elems[i], width)
 		})
 	}
 }
@@ -153,7 +166,7 @@ func decodeArray(v reflect.Value) DecodeFunc {
 				elem = v.Index(i)
 			}
 			if err := parser.ParseAny(elem); err != nil {
-				return err
+				return withIndex(err, i)
 			}
 			return nil
 		})
@@ -182,13 +195,16 @@ func decodeSlice(v reflect.Value) DecodeFunc {
 			v.Set(reflect.Zero(v.Type()))
 			return nil
 		}
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
 		return parser.ParseList(func(i int) error {
 			if i == v.Len() {
 				v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
 			}
 			elem := v.Index(i)
 			if err := parser.ParseAny(elem); err != nil {
-				return err
+				return withIndex(err, i)
 			}
 			return nil
 		})
@@ -204,39 +220,62 @@ func matchStruct(v reflect.Value) (*Encoding, bool) {
 
 func encodeStruct(v reflect.Value) EncodeFunc {
 	return func(c Composer) error {
-		t := v.Type()
-		fieldNameMax := 0
+		keyMax := takeKeyWidth(c)
+		fs, values := structFields(v)
 		if c.Indented() {
-			for i := 0; i < t.NumField(); i++ {
-				l := len(t.Field(i).Name)
-				if l > fieldNameMax {
-					fieldNameMax = l
+			for _, f := range fs {
+				if l := len(f.name); l > keyMax {
+					keyMax = l
 				}
 			}
 		}
-		return c.ComposeList(t.NumField(), func(i int) error {
-			fieldName := t.Field(i).Name
-			composeValue(c, fieldName)
-			composeValue(c, " ")
+		width, err := nestedKeyWidth(c, values)
+		if err != nil {
+			return err
+		}
+		return c.ComposeList(len(fs), func(i int) error {
+			f := fs[i]
+			composeKey(c, f.name, reflect.Value{})
 			//composeValue(c, ": ")
 			if c.Indented() {
-				composeValue(c, strings.Repeat(" ", fieldNameMax-len(fieldName)))
+				composePadding(c, keyMax-len(f.name))
 			}
-			return c.ComposeAny(v.Field(i))
+			if f.quoted {
+				return composeQuoted(c, values[i])
+			}
+			return composeAligned(c, values[i], width)
 		})
 	}
 }
 
+// structFields returns the fields of a struct value that are encoded, with
+// their values.
+func structFields(v reflect.Value) (fs []field, values []reflect.Value) {
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		fs = append(fs, f)
+		values = append(values, fv)
+	}
+	return fs, values
+}
+
 func decodeStruct(v reflect.Value) DecodeFunc {
 	return func(parser Parser) error {
+		fs := cachedFields(v.Type())
 		return parser.ParseList(func(int) error {
 			var fieldName string
 			if err := parser.ParseAny(reflect.ValueOf(&fieldName)); err != nil {
 				return err
 			}
 			elem := reflect.Value{}
-			if field := v.FieldByName(string(fieldName)); field.CanSet() {
-				elem = field
+			f, ok := fieldByName(fs, fieldName)
+			if ok {
+				if field := allocFieldByIndex(v, f.index); field.CanSet() {
+					elem = field
+				}
 			}
 			/*
 			if err := parser.GoToOnlyChild(); err != nil {
@@ -246,14 +285,56 @@ func decodeStruct(v reflect.Value) DecodeFunc {
 				return err
 			}
 			*/
+			if ok && f.quoted {
+				return withField(parseQuoted(parser, elem), f.name)
+			}
 			if err := parser.ParseAny(elem); err != nil {
-				return err
+				return withField(err, fieldName)
 			}
 			return nil
 		})
 	}
 }
 
+// composeQuoted writes a scalar as a quoted string, as requested by the
+// "string" tag option.
+func composeQuoted(c Composer, v reflect.Value) error {
+	e, ok := typeToValueEncoding[v.Kind()]
+	if !ok || v.Kind() == reflect.String {
+		return c.ComposeAny(v)
+	}
+	var buf bytes.Buffer
+	if err := e.Encode(v, &buf); err != nil {
+		return err
+	}
+	return c.ComposeAny(reflect.ValueOf(buf.String()))
+}
+
+func parseQuoted(parser Parser, v reflect.Value) error {
+	e, ok := typeToValueEncoding[v.Kind()]
+	if !ok || v.Kind() == reflect.String {
+		return parser.ParseAny(v)
+	}
+	return parser.ParseAny(reflect.ValueOf(&quotedValue{v, e}))
+}
+
+// quotedValue decodes a scalar from a quoted string through the Unmarshaler
+// path, which does the unquoting.
+type quotedValue struct {
+	v reflect.Value
+	e ValueEncoding
+}
+
+func (q *quotedValue) MarshalOGDL() ([]byte, error) {
+	var buf bytes.Buffer
+	err := q.e.Encode(q.v, &buf)
+	return buf.Bytes(), err
+}
+
+func (q *quotedValue) UnmarshalOGDL(text []byte) error {
+	return q.e.Decode(text, q.v)
+}
+
 func matchMap(v reflect.Value) (*Encoding, bool) {
 	if v.Kind() != reflect.Map {
 		return nil, false
@@ -267,34 +348,57 @@ func encodeMap(v reflect.Value) EncodeFunc {
 			composeNil(c)
 			return nil
 		}
-		var keys stringValues = v.MapKeys()
-		sort.Sort(keys)
-		/*
-			keyMax := 0
-			if c.indented {
-				for _, key := range keys {
-					l := len(key.String())
-					if l > keyMax {
-						keyMax = l
-					}
-				}
+		keyMax := takeKeyWidth(c)
+		keys, err := sortedKeys(c, v)
+		if err != nil {
+			return err
+		}
+		values := make([]reflect.Value, len(keys))
+		for i, key := range keys {
+			if l := len(key.text); c.Indented() && l > keyMax {
+				keyMax = l
 			}
-		*/
-		return c.ComposeList(v.Len(), func(i int) error {
+			values[i] = v.MapIndex(key.value)
+		}
+		width, err := nestedKeyWidth(c, values)
+		if err != nil {
+			return err
+		}
+		return c.ComposeList(len(keys), func(i int) error {
 			key := keys[i]
-			composeValue(c, encodeKey(key))
-			composeValue(c, " ")
+			if err := composeKey(c, key.text, key.value); err != nil {
+				return err
+			}
 			//composeValue(c, ": ")
-			/*
-				if c.indented {
-					composeValue(c, strings.Repeat(" ", keyMax-len(key.String())))
-				}
-			*/
-			return c.ComposeAny(v.MapIndex(key))
+			if c.Indented() {
+				composePadding(c, keyMax-len(key.text))
+			}
+			return composeAligned(c, values[i], width)
 		})
 	}
 }
 
+// sortedKeys returns the keys of a map value in the order of mapKeys, so
+// that a map is always written in the same order, encoded with the options
+// of w and, if it is an Encoder, once per map.
+func sortedKeys(w io.Writer, v reflect.Value) (mapKeys, error) {
+	if e, ok := w.(interface {
+		sortedKeys(v reflect.Value) (mapKeys, error)
+	}); ok {
+		return e.sortedKeys(v)
+	}
+	keys := make(mapKeys, v.Len())
+	for i, key := range v.MapKeys() {
+		text, err := encodeKey(w, key)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = mapKey{key, text}
+	}
+	sort.Sort(keys)
+	return keys, nil
+}
+
 func decodeMap(v reflect.Value) DecodeFunc {
 	return func(parser Parser) error {
 		if isNil(parser) {
@@ -319,7 +423,8 @@ func decodeMap(v reflect.Value) DecodeFunc {
 			}
 			*/
 			if err := parser.ParseAny(elem); err != nil {
-				return err
+				text, _ := encodeKey(nil, key)
+				return withField(err, "["+text+"]")
 			}
 			v.SetMapIndex(key, elem)
 			return nil
@@ -341,25 +446,312 @@ func skipColon(parser Parser) error {
 	return nil
 }
 
-func encodeKey(v reflect.Value) string {
+// encodeKey encodes a map key with the options of w, through the buffer for
+// keys of w if it is an Encoder.
+func encodeKey(w io.Writer, v reflect.Value) (string, error) {
+	if e, ok := w.(interface {
+		encodeKey(v reflect.Value) (string, error)
+	}); ok {
+		return e.encodeKey(v)
+	}
 	var buf bytes.Buffer
 	en := NewEncoder(&buf)
-	en.Encode(v)
-	return buf.String()
+	en.opts = *optionsOf(w)
+	err := en.Encode(v)
+	return buf.String(), err
+}
+
+// composeKey writes the key of a list element, as text followed by a space,
+// or as the map key value in the binary form if it is a typed scalar.
+func composeKey(c Composer, text string, value reflect.Value) error {
+	if k, ok := c.(interface {
+		composeKey(text string, value reflect.Value) error
+	}); ok {
+		return k.composeKey(text, value)
+	}
+	composeValue(c, text)
+	return composeValue(c, " ")
 }
 
 func composeNil(c Composer) error {
 	return composeValue(c, "nil")
 }
 
+// composePadding writes n spaces that align the values of a list, which a
+// list kept on one line leaves out.
+func composePadding(c Composer, n int) {
+	if p, ok := c.(interface {
+		pad(n int)
+	}); ok {
+		p.pad(n)
+		return
+	}
+	composeValue(c, strings.Repeat(" ", n))
+}
+
+// keyAligner is implemented by a Composer that aligns the values of the
+// struct and map elements of a list with each other.
+type keyAligner interface {
+	alignsNested() bool
+	setKeyWidth(n int)
+	takeKeyWidth() int
+}
+
+// nestedKeyWidth returns the width of the widest key of the struct and map
+// values in elems, which they are all aligned to, or 0 unless c aligns
+// nested lists.
+func nestedKeyWidth(c Composer, elems []reflect.Value) (int, error) {
+	if a, ok := c.(keyAligner); !ok || !a.alignsNested() || !c.Indented() {
+		return 0, nil
+	}
+	max := 0
+	for _, elem := range elems {
+		l, err := keyWidth(c, elem)
+		if err != nil {
+			return 0, err
+		}
+		if l > max {
+			max = l
+		}
+	}
+	return max, nil
+}
+
+// keyWidth returns the width of the widest key of a struct or map value, or 0
+// for any other value.
+func keyWidth(w io.Writer, v reflect.Value) (int, error) {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	max := 0
+	switch v.Kind() {
+	case reflect.Struct:
+		fs, _ := structFields(v)
+		for _, f := range fs {
+			if l := len(f.name); l > max {
+				max = l
+			}
+		}
+	case reflect.Map:
+		keys, err := sortedKeys(w, v)
+		if err != nil {
+			return 0, err
+		}
+		for _, key := range keys {
+			if len(key.text) > max {
+				max = len(key.text)
+			}
+		}
+	}
+	return max, nil
+}
+
+// composeAligned composes v with its keys aligned to width if it is a struct
+// or a map.
+func composeAligned(c Composer, v reflect.Value, width int) error {
+	if a, ok := c.(keyAligner); ok && width > 0 {
+		a.setKeyWidth(width)
+		defer a.setKeyWidth(0)
+	}
+	return c.ComposeAny(v)
+}
+
+// takeKeyWidth returns the width set by composeAligned for the list being
+// composed, so that it does not apply to the lists in it.
+func takeKeyWidth(c Composer) int {
+	if a, ok := c.(keyAligner); ok {
+		return a.takeKeyWidth()
+	}
+	return 0
+}
+
 func composeValue(c Composer, s string) error {
 	_, err := c.Write([]byte(s))
 	return err
 }
 
-type stringValues []reflect.Value
+type mapKey struct {
+	value reflect.Value
+	text  string
+}
 
-func (sv stringValues) Len() int           { return len(sv) }
-func (sv stringValues) Swap(i, j int)      { sv[i], sv[j] = sv[j], sv[i] }
-func (sv stringValues) Less(i, j int) bool { return sv.get(i) < sv.get(j) }
-func (sv stringValues) get(i int) string   { return sv[i].String() }
+type mapKeys []mapKey
+
+func (ks mapKeys) Len() int      { return len(ks) }
+func (ks mapKeys) Swap(i, j int) { ks[i], ks[j] = ks[j], ks[i] }
+
+// Less orders keys by value, pointers by the values they point to, and
+// keys of the same value by their text.
+func (ks mapKeys) Less(i, j int) bool {
+	if c := compareKeys(indirectKey(ks[i].value), indirectKey(ks[j].value)); c != 0 {
+		return c < 0
+	}
+	return ks[i].text < ks[j].text
+}
+
+// compareKeys compares two map keys by value: booleans, numbers, strings,
+// then arrays and structs element by element. It returns 0 for keys that
+// are equal or are not ordered by value, like pointers within a key.
+func compareKeys(a, b reflect.Value) int {
+	for a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+	ra, rb := keyRank(a), keyRank(b)
+	switch {
+	case ra != rb:
+		return ra - rb
+	case ra == keyRankComposite && a.Type() != b.Type():
+		return strings.Compare(a.Type().String(), b.Type().String())
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		return compareBool(a.Bool(), b.Bool())
+	case reflect.String:
+		if ra == keyRankString {
+			return strings.Compare(a.String(), b.String())
+		}
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if c := compareKeys(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Struct:
+		for _, f := range cachedFields(a.Type()) {
+			fa, oka := fieldByIndex(a, f.index)
+			fb, okb := fieldByIndex(b, f.index)
+			if !oka || !okb {
+				continue
+			}
+			if c := compareKeys(fa, fb); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	if ra == keyRankNumber {
+		return compareNumbers(a, b)
+	}
+	return 0
+}
+
+func indirectKey(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem()
+	}
+	return v
+}
+
+const (
+	keyRankBool = iota
+	keyRankNumber
+	keyRankString
+	keyRankComposite
+	keyRankOther
+)
+
+func keyRank(v reflect.Value) int {
+	if v.Type() == numberType {
+		if _, err := v.Interface().(Number).Float64(); err == nil {
+			return keyRankNumber
+		}
+		return keyRankString
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return keyRankBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return keyRankNumber
+	case reflect.String:
+		return keyRankString
+	case reflect.Array, reflect.Struct:
+		return keyRankComposite
+	}
+	return keyRankOther
+}
+
+// compareNumbers compares two integer or floating point values of any type,
+// exactly unless one of them is a floating point value. NaN is less than any
+// other number.
+func compareNumbers(a, b reflect.Value) int {
+	ia, ua, fa := numberParts(a)
+	ib, ub, fb := numberParts(b)
+	switch {
+	case ua && ub:
+		return compareUint(a.Uint(), b.Uint())
+	case ia && ib:
+		return compareInt(a.Int(), b.Int())
+	case ia && ub:
+		if a.Int() < 0 {
+			return -1
+		}
+		return compareUint(uint64(a.Int()), b.Uint())
+	case ua && ib:
+		if b.Int() < 0 {
+			return 1
+		}
+		return compareUint(a.Uint(), uint64(b.Int()))
+	}
+	nanA, nanB := math.IsNaN(fa), math.IsNaN(fb)
+	switch {
+	case nanA || nanB:
+		return compareBool(nanB, nanA)
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+// numberParts reports whether v is a signed or an unsigned integer, and
+// returns its value as a float64. v may also be a Number.
+func numberParts(v reflect.Value) (signed, unsigned bool, f float64) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true, false, float64(v.Int())
+	case reflect.Float32, reflect.Float64:
+		return false, false, v.Float()
+	case reflect.String:
+		f, _ := Number(v.String()).Float64()
+		return false, false, f
+	}
+	return false, true, float64(v.Uint())
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareBool orders false before true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	}
+	return 1
+}
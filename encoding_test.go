@@ -6,9 +6,14 @@ package flow
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
+	"testing/iotest"
 	"time"
 
 	"h12.io/gspec"
@@ -27,6 +32,40 @@ type structKey struct {
 	SKey string
 }
 
+var errTest = errors.New("test error")
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errTest
+}
+
+type countingWriter struct {
+	n, writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += len(p)
+	w.writes++
+	return len(p), nil
+}
+
+// countedKey counts how many times it is encoded.
+type countedKey struct{ N int }
+
+var countedKeyEncodings int
+
+func (k countedKey) MarshalText() ([]byte, error) {
+	countedKeyEncodings++
+	return []byte(strconv.Itoa(k.N)), nil
+}
+
+func (k *countedKey) UnmarshalText(text []byte) error {
+	var err error
+	k.N, err = strconv.Atoi(string(text))
+	return err
+}
+
 func newValue(v interface{}) reflect.Value {
 	t := reflect.TypeOf(v)
 	if t == nil {
@@ -110,6 +149,12 @@ var _encodingTestGroups = encodingTestGroups{
 		[]encodingTestCase{
 			{float32(1.234), "1.234"},
 			{float64(5.678), "5.678"},
+			{pointOne + pointTwo, "0.30000000000000004"},
+			{float32(pointOne) + float32(pointTwo), "0.3"},
+			{1e300, "1e+300"},
+			{math.Inf(1), "+Inf"},
+			{math.Inf(-1), "-Inf"},
+			{float32(math.Inf(-1)), "-Inf"},
 		},
 	},
 
@@ -119,19 +164,28 @@ var _encodingTestGroups = encodingTestGroups{
 			{complex128(4.5 - 6.7i), "4.5-6.7i"},
 			{complex64(4.5i), "0+4.5i"},
 			{complex128(4.5), "4.5+0i"},
+			{complex(math.Inf(-1), math.Inf(1)), "-Inf+Infi"},
+			{complex(1, math.Inf(-1)), "1-Infi"},
 		},
 	},
 
 	{"string literals",
 		[]encodingTestCase{
 			{"a", `"a"`},
-			{"\n", `"\n"`},
+			{"\n", "`\n`"},
+			{"a\n\tb", "`a\n\tb`"},
+			{"a`\nb", "\"a`\\nb\""},
+			{"a\r\nb", `"a\r\nb"`},
+			{`C:\`, `"C:\\"`},
+			{`\"`, `"\\\""`},
+			{"\x00\u00e9\U0001F600", `"\x00é😀"`},
 		},
 	},
 
 	{"slice",
 		[]encodingTestCase{
 			{[]int(nil), "nil"},
+			{[]int{}, "{}"},
 			{[]int{1}, "{1}"},
 			{[]int{1, 2}, "{1, 2}"},
 		},
@@ -155,6 +209,62 @@ var _encodingTestGroups = encodingTestGroups{
 		},
 	},
 
+	{"struct tags",
+		[]encodingTestCase{
+			{struct {
+				IVal int `flow:"i"`
+			}{IVal: 1}, "{i 1}"},
+			{struct {
+				IVal int
+				SVal string `flow:"-"`
+			}{IVal: 1}, "{IVal 1}"},
+			{struct {
+				Dash int `flow:"-,"`
+			}{Dash: 1}, "{- 1}"},
+			{struct {
+				IVal int    `flow:",omitempty"`
+				SVal string `flow:"s,omitempty"`
+			}{}, "{}"},
+			{struct {
+				IVal int    `flow:",omitempty"`
+				SVal string `flow:"s,omitempty"`
+			}{IVal: 1, SVal: "a"}, `{IVal 1, s "a"}`},
+			{struct {
+				IVal int    `flow:"i,string"`
+				BVal bool   `flow:",omitempty,string"`
+				SVal string `flow:",string"`
+			}{IVal: 80, BVal: true, SVal: "a"}, `{i "80", BVal "true", SVal "a"}`},
+			{struct {
+				Bad int `flow:"a b"`
+			}{Bad: 1}, "{Bad 1}"},
+		},
+	},
+
+	{"unexported fields",
+		[]encodingTestCase{
+			{struct {
+				IVal int
+				sVal string
+			}{IVal: 1}, "{IVal 1}"},
+			{struct {
+				iVal int
+				sVal string
+			}{}, "{}"},
+		},
+	},
+
+	{"embedded struct",
+		[]encodingTestCase{
+			{embeddingStruct{EmbeddedBase{ID: 1}, "b"}, `{ID 1, Name "b"}`},
+			{embeddingPtrStruct{&EmbeddedBase{1, "a"}, 2}, `{ID 1, Name "a", Extra 2}`},
+			{embeddingPtrStruct{nil, 2}, `{Extra 2}`},
+			{namedEmbeddingStruct{EmbeddedBase{1, "a"}, 2}, `{Base {ID 1, Name "a"}, Extra 2}`},
+			{unexportedEmbeddingStruct{embeddedBase{1}, 2}, `{ID 1, Extra 2}`},
+			{conflictingEmbeddingStruct{Y: 1}, `{Y 1}`},
+			{taggedEmbeddingStruct{EmbeddedBase{Name: "a"}, embeddedTagged{ID: 1}}, `{Name "a", ID 1}`},
+		},
+	},
+
 	{"map",
 		[]encodingTestCase{
 			{map[string]bool(nil), "nil"},
@@ -163,6 +273,12 @@ var _encodingTestGroups = encodingTestGroups{
 			{map[int]bool{1: true, 2: false}, `{1 true, 2 false}`},
 			{map[structKey]bool{structKey{1, "a"}: true, structKey{2, "b"}: false},
 				`{{IKey 1, SKey "a"} true, {IKey 2, SKey "b"} false}`},
+			{map[int]bool{100: true, 9: false, 10: true, -1: false}, `{-1 false, 9 false, 10 true, 100 true}`},
+			{map[float64]int{0.5: 1, -2: 2, 10: 3}, `{-2 2, 0.5 1, 10 3}`},
+			{map[bool]int{true: 1, false: 0}, `{false 0, true 1}`},
+			{map[string]int{"b c": 1, "a": 2}, `{"a" 2, "b c" 1}`},
+			{map[structKey]bool{structKey{10, "a"}: true, structKey{9, "b"}: false},
+				`{{IKey 9, SKey "b"} false, {IKey 10, SKey "a"} true}`},
 		},
 	},
 
@@ -215,12 +331,78 @@ var _encodingTestGroups = encodingTestGroups{
 		[]encodingTestCase{
 			{INT(1), "!INT 1"},
 			{struct{ I interface{} }{1}, "{I !int 1}"},
+			{struct{ I interface{} }{"a"}, `{I "a"}`},
+			{struct{ I interface{} }{}, "{I nil}"},
+			{struct{ I interface{} }{Number("1.5")}, "{I 1.5}"},
+			{struct{ I interface{} }{[]interface{}{true, "a"}}, `{I {true, "a"}}`},
+		},
+	},
+
+	{"generic values",
+		[]encodingTestCase{
+			{Number("42"), "42"},
+			{Number("-0x1F"), "-0x1F"},
+			{Map{}, "{}"},
+			{Map{{"a", Number("1")}, {"b", []interface{}{true, nil, "x"}}},
+				`{"a" 1, "b" {true, nil, "x"}}`},
+			{Map{{"a", Map{{Number("1"), []interface{}{}}}}}, `{"a" {1 {}}}`},
+			{[]interface{}{Number("1"), Map{{"k", "v"}}, []interface{}{}},
+				`{1, {"k" "v"}, {}}`},
 		},
 	},
 }
 
 type INT int
 
+var pointOne, pointTwo = 0.1, 0.2
+
+type EmbeddedBase struct {
+	ID   int
+	Name string
+}
+
+type embeddedBase struct {
+	ID int
+}
+
+type embeddingStruct struct {
+	EmbeddedBase
+	Name string
+}
+
+type embeddingPtrStruct struct {
+	*EmbeddedBase
+	Extra int
+}
+
+type namedEmbeddingStruct struct {
+	EmbeddedBase `flow:"Base"`
+	Extra        int
+}
+
+type unexportedEmbeddingStruct struct {
+	embeddedBase
+	Extra int
+}
+
+type conflictA struct{ X int }
+type conflictB struct{ X int }
+
+type conflictingEmbeddingStruct struct {
+	conflictA
+	conflictB
+	Y int
+}
+
+type embeddedTagged struct {
+	ID int `flow:"ID"`
+}
+
+type taggedEmbeddingStruct struct {
+	EmbeddedBase
+	embeddedTagged
+}
+
 type structType struct {
 	IVal int
 }
@@ -229,13 +411,14 @@ var marshalIndentTestGroups = encodingTestGroups{
 	{"slice",
 		[]encodingTestCase{
 			{[]int(nil), " nil"},
-			{[]int{1, 2}, " {\n   1,\n   2,\n }"},
+			{[]int{1, 2}, " {1, 2}"},
+			{make([]int, 30), " {\n   " + strings.Repeat("0,\n   ", 29) + "0,\n }"},
 		},
 	},
 
 	{"array",
 		[]encodingTestCase{
-			{[...]int{1, 2}, " {\n   1,\n   2,\n }"},
+			{[...]int{1, 2}, " {1, 2}"},
 		},
 	},
 
@@ -245,7 +428,18 @@ var marshalIndentTestGroups = encodingTestGroups{
 			{struct {
 				IVal int
 				SVal string
-			}{IVal: 1, SVal: "a"}, " {\n   IVal 1,\n   SVal \"a\",\n }"},
+			}{IVal: 1, SVal: "a"}, " {IVal 1, SVal \"a\"}"},
+			{struct {
+				IVal int    `flow:"i"`
+				SVal string `flow:"long"`
+				Skip int    `flow:",omitempty"`
+			}{IVal: 1, SVal: "a"}, " {i 1, long \"a\"}"},
+			{struct {
+				IVal int    `flow:"i"`
+				SVal string `flow:"long"`
+			}{IVal: 1, SVal: strings.Repeat("a", 80)},
+				" {\n   i    1,\n   long \"" + strings.Repeat("a", 80) + "\",\n }"},
+			{struct{ S string }{"a\nb"}, " {\n   S `a\nb`,\n }"},
 		},
 	},
 
@@ -253,13 +447,25 @@ var marshalIndentTestGroups = encodingTestGroups{
 		[]encodingTestCase{
 			{map[string]bool(nil), " nil"},
 			{make(map[string]bool), " {}"},
-			{map[int]bool{1: true, 2: false}, " {\n   1 true,\n   2 false,\n }"},
+			{map[int]bool{1: true, 2: false}, " {1 true, 2 false}"},
 			{
 				map[structKey]bool{
 					structKey{1, "a"}: true,
 					structKey{2, "b"}: false,
 				},
-				" {\n   {IKey 1, SKey \"a\"} true,\n   {IKey 2, SKey \"b\"} false,\n }",
+				` {{IKey 1, SKey "a"} true, {IKey 2, SKey "b"} false}`,
+			},
+			{
+				map[string]string{"a": strings.Repeat("x", 40), "long": strings.Repeat("y", 40)},
+				" {\n   \"a\"    \"" + strings.Repeat("x", 40) + "\",\n   \"long\" \"" + strings.Repeat("y", 40) + "\",\n }",
+			},
+			{
+				map[structKey]string{
+					structKey{1, "a"}:  strings.Repeat("x", 40),
+					structKey{10, "b"}: strings.Repeat("y", 40),
+				},
+				" {\n   {IKey 1, SKey \"a\"}  \"" + strings.Repeat("x", 40) +
+					"\",\n   {IKey 10, SKey \"b\"} \"" + strings.Repeat("y", 40) + "\",\n }",
 			},
 		},
 	},
@@ -279,6 +485,292 @@ var _ = gspec.Add(func(s gspec.S) {
 			expect(err).Equal(nil)
 			expect(buf.String()).Equal(tc.text)
 		})
+		testcase := s.Alias("testcase")
+		testcase("unexported fields are not encoded", func() {
+			t := time.Now()
+			r, err := Marshal(&struct {
+				IVal int
+				sVal string
+				t    *time.Time
+				p    *int
+			}{1, "secret", &t, new(int)})
+			expect(err).Equal(nil)
+			expect(string(r)).Equal("{IVal 1}")
+		})
+		testcase("unexported fields do not define references", func() {
+			i := 1
+			r, err := Marshal(&struct {
+				P *int
+				p *int
+			}{&i, &i})
+			expect(err).Equal(nil)
+			expect(string(r)).Equal("{P 1}")
+		})
+		testcase("unsigned integers in hex", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetHexUint(true)
+			v := struct {
+				Mode  uint32
+				Mask  map[uint8]bool
+				Count int
+			}{0755, map[uint8]bool{255: true}, 10}
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal("{Mode 0x1ed, Mask {0xff true}, Count 10}")
+			w := v
+			w.Mode, w.Mask = 0, nil
+			expect(Unmarshal(buf.Bytes(), &w)).Equal(nil)
+			expect(w).Equal(v)
+		})
+		testcase("NaN", func() {
+			r, err := Marshal([]interface{}{math.NaN(), complex(math.NaN(), math.NaN())})
+			expect(err).Equal(nil)
+			expect(string(r)).Equal("{!float64 NaN, !complex128 NaN+NaNi}")
+		})
+		testcase("float format", func() {
+			for _, tc := range []struct {
+				format byte
+				prec   int
+				text   string
+			}{
+				{'f', 2, "{A 3.14, B 100.00, C 0.00+1.50i, D +Inf}"},
+				{'e', 3, "{A 3.142e+00, B 1.000e+02, C 0.000e+00+1.500e+00i, D +Inf}"},
+				{'g', 3, "{A 3.14, B 100, C 0+1.5i, D +Inf}"},
+				{'g', -1, "{A 3.14159, B 100, C 0+1.5i, D +Inf}"},
+			} {
+				var buf bytes.Buffer
+				enc := NewEncoder(&buf)
+				enc.SetFloatFormat(tc.format, tc.prec)
+				expect(enc.Encode(struct {
+					A, B float64
+					C    complex128
+					D    float32
+				}{3.14159, 100, 1.5i, float32(math.Inf(1))})).Equal(nil)
+				expect(buf.String()).Equal(tc.text)
+			}
+		})
+		testcase("successive values", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			c := &cyclicStruct{}
+			c.P = c
+			expect(enc.Encode(1)).Equal(nil)
+			expect(enc.Encode(c)).Equal(nil)
+			expect(enc.Encode(c)).Equal(nil)
+			expect(enc.Encode([]string{"a"})).Equal(nil)
+			expect(buf.String()).Equal("1\n^1 {P ^1}\n^1 {P ^1}\n{\"a\"}")
+			dec := NewDecoder(&buf)
+			var i int
+			expect(dec.Decode(&i)).Equal(nil)
+			expect(i).Equal(1)
+		})
+		testcase("writes each value at once", func() {
+			var w countingWriter
+			enc := NewEncoder(&w)
+			expect(enc.Encode(make([]int, 10000))).Equal(nil)
+			expect(w.writes).Equal(1)
+			expect(w.n).Equal(len("{}") + 10000*len("0, ") - len(", "))
+			expect(enc.Encode(1)).Equal(nil)
+			expect(w.writes).Equal(2)
+		})
+		testcase("writes nothing on errors", func() {
+			type invalid struct {
+				A int
+				C chan int
+			}
+			for _, indent := range []string{"", "  "} {
+				var buf bytes.Buffer
+				enc := NewEncoder(&buf)
+				enc.SetIndent("", indent)
+				expect(enc.Encode(invalid{1, make(chan int)})).NotEqual(nil)
+				expect(buf.String()).Equal("")
+				expect(enc.Encode(2)).Equal(nil)
+				expect(enc.Encode([]invalid{{}})).NotEqual(nil)
+				expect(enc.Encode(3)).Equal(nil)
+				expect(buf.String()).Equal("2\n3")
+			}
+			var buf bytes.Buffer
+			enc := NewBinaryEncoder(&buf)
+			expect(enc.Encode(invalid{1, make(chan int)})).NotEqual(nil)
+			expect(enc.Encode("a")).Equal(nil)
+			dec := NewBinaryDecoder(&buf)
+			var s string
+			expect(dec.Decode(&s)).Equal(nil)
+			expect(s).Equal("a")
+			expect(dec.More()).Equal(false)
+		})
+		testcase("write errors", func() {
+			enc := NewEncoder(errWriter{})
+			expect(enc.Encode(1)).Equal(errTest)
+			expect(enc.Encode(2)).Equal(errTest)
+			enc = NewEncoder(errWriter{})
+			expect(enc.Encode(make([]int, 10000))).Equal(errTest)
+		})
+		testcase("map key errors", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			expect(enc.Encode(map[string]int{"b": 2, "a": 1})).Equal(nil)
+			expect(enc.Encode(map[int]string{1: "a"})).Equal(nil)
+			expect(buf.String()).Equal("{\"a\" 1, \"b\" 2}\n{1 \"a\"}")
+			expect(enc.Encode(map[chan int]int{make(chan int): 1})).NotEqual(nil)
+		})
+		testcase("indent", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetIndent("> ", "  ")
+			enc.SetMaxWidth(0)
+			v := struct {
+				A  []int
+				BB string
+			}{[]int{1, 2}, "x"}
+			expect(enc.Encode(v)).Equal(nil)
+			expect(enc.Encode(1)).Equal(nil)
+			expect(buf.String()).Equal("> {\n>   A  {\n>     1,\n>     2,\n>   },\n>   BB \"x\",\n> }\n> 1")
+			enc.SetIndent("", "")
+			buf.Reset()
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal("\n{A {1, 2}, BB \"x\"}")
+		})
+		testcase("list style", func() {
+			v := struct {
+				A []int
+				B []int
+			}{[]int{1, 2}, nil}
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetCompactSeparator(true)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal("{A {1,2},B nil}")
+			buf.Reset()
+			enc = NewEncoder(&buf)
+			enc.SetIndent("", "\t")
+			enc.SetMaxWidth(0)
+			enc.SetTrailingComma(false)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal("{\n\tA {\n\t\t1,\n\t\t2\n\t},\n\tB nil\n}")
+		})
+		testcase("max width", func() {
+			v := struct {
+				Short []int
+				Long  []string
+				Empty []int
+				S     struct{ A, BB int }
+			}{[]int{1, 2}, []string{"alpha", "beta", "gamma"}, []int{}, struct{ A, BB int }{1, 2}}
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetIndent("", "    ")
+			enc.SetMaxWidth(24)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`{
+    Short {1, 2},
+    Long  {
+        "alpha",
+        "beta",
+        "gamma",
+    },
+    Empty {},
+    S     {A 1, BB 2},
+}`)
+			var w struct {
+				Short []int
+				Long  []string
+				Empty []int
+				S     struct{ A, BB int }
+			}
+			expect(Unmarshal(buf.Bytes(), &w)).Equal(nil)
+			expect(w).Equal(v)
+			buf.Reset()
+			enc.SetMaxWidth(80)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`
+{Short {1, 2}, Long {"alpha", "beta", "gamma"}, Empty {}, S {A 1, BB 2}}`)
+		})
+		testcase("max width of nested lists", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			indent := strings.Repeat(" ", 40)
+			enc.SetIndent("", indent)
+			enc.SetMaxWidth(60)
+			l := func() []int { return []int{10, 11, 12, 13, 14, 15, 16, 17} }
+			expect(enc.Encode([][]int{l(), l()})).Equal(nil)
+			inner := "_{\n__10,\n__11,\n__12,\n__13,\n__14,\n__15,\n__16,\n__17,\n_},\n"
+			expect(strings.Replace(buf.String(), indent, "_", -1)).Equal("{\n" + inner + inner + "}")
+		})
+		testcase("align nested", func() {
+			type server struct {
+				Host string
+				Port int `flow:",omitempty"`
+			}
+			v := map[string]server{
+				"db":  {Host: "db.example.com", Port: 5432},
+				"web": {Host: "web.example.com"},
+			}
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			enc.SetMaxWidth(0)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`{
+  "db"  {
+    Host "db.example.com",
+    Port 5432,
+  },
+  "web" {
+    Host "web.example.com",
+  },
+}`)
+			buf.Reset()
+			enc = NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			enc.SetMaxWidth(0)
+			enc.SetAlignNested(true)
+			expect(enc.Encode([]map[string]int{{"x": 1, "yyy": 2}, {"zz": 3}})).Equal(nil)
+			expect(buf.String()).Equal(`{
+  {
+    "x"   1,
+    "yyy" 2,
+  },
+  {
+    "zz"  3,
+  },
+}`)
+		})
+		testcase("map keys", func() {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			enc.SetAlignNested(true)
+			countedKeyEncodings = 0
+			v := []map[*countedKey]int{{{10}: 1, {9}: 2}, {{100}: 3}}
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`{{9 2, 10 1}, {100 3}}`)
+			expect(countedKeyEncodings).Equal(3)
+			r, err := Marshal(map[interface{}]int{"a": 1, Number("2"): 2, true: 3, Number("10"): 4})
+			expect(err).Equal(nil)
+			expect(string(r)).Equal(`{true 3, 2 2, 10 4, "a" 1}`)
+		})
+		testcase("nested lists", func() {
+			v := [][]string{{"alpha", "beta"}, {"gamma", "delta", "epsilon", "zeta"}}
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			enc.SetMaxWidth(30)
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`{
+  {"alpha", "beta"},
+  {
+    "gamma",
+    "delta",
+    "epsilon",
+    "zeta",
+  },
+}`)
+			buf.Reset()
+			enc = NewEncoder(&buf)
+			enc.SetIndent("", "  ")
+			expect(enc.Encode(v)).Equal(nil)
+			expect(buf.String()).Equal(`{{"alpha", "beta"}, {"gamma", "delta", "epsilon", "zeta"}}`)
+		})
 		marshalIndentTestGroups.Test("encoding and indenting", s, func(tc encodingTestCase) {
 			r, err := MarshalIndent(tc.value, " ", "  ")
 			expect(err).Equal(nil)
@@ -297,4 +789,402 @@ var _ = gspec.Add(func(s gspec.S) {
 			}
 		})
 	})
+
+	describe("Decoder stream", func() {
+		testcase := s.Alias("testcase")
+		testcase("multiple documents", func() {
+			dec := NewDecoder(strings.NewReader("{1, 2}\n// next\n{3} {4} ^1 {P ^1}\n"))
+			var a []int
+			var offsets []int64
+			for dec.More() {
+				a = nil
+				expect(dec.Decode(&a)).Equal(nil)
+				offsets = append(offsets, dec.InputOffset())
+				if len(offsets) == 3 {
+					break
+				}
+			}
+			expect(a).Equal([]int{4})
+			expect(offsets).Equal([]int64{6, 18, 22})
+			var c *cyclicStruct
+			expect(dec.Decode(&c)).Equal(nil)
+			expect(dec.More()).Equal(false)
+			expect(dec.Decode(&c)).Equal(io.EOF)
+		})
+		testcase("references are scoped to a document", func() {
+			dec := NewDecoder(strings.NewReader("{I ^1 1, J ^1} {I ^1 2, J ^1}"))
+			var v1, v2 struct{ I, J *int }
+			expect(dec.Decode(&v1)).Equal(nil)
+			expect(dec.Decode(&v2)).Equal(nil)
+			expect(*v1.J).Equal(1)
+			expect(*v2.J).Equal(2)
+		})
+		testcase("no read ahead", func() {
+			dec := NewDecoder(io.MultiReader(strings.NewReader("{1} "), iotest.ErrReader(errTest)))
+			var a []int
+			expect(dec.Decode(&a)).Equal(nil)
+			expect(a).Equal([]int{1})
+			expect(dec.InputOffset()).Equal(int64(3))
+			expect(dec.Decode(&a)).Equal(errTest)
+		})
+		testcase("buffered", func() {
+			dec := NewDecoder(strings.NewReader("{1} {2} tail"))
+			var a []int
+			expect(dec.Decode(&a)).Equal(nil)
+			b, _ := ioutil.ReadAll(dec.Buffered())
+			expect(string(b)).Equal(" {2} tail")
+			expect(dec.More()).Equal(true)
+			b, _ = ioutil.ReadAll(dec.Buffered())
+			expect(string(b)).Equal("{2} tail")
+			expect(dec.InputOffset()).Equal(int64(4))
+		})
+		testcase("syntax error", func() {
+			dec := NewDecoder(strings.NewReader("{1} {2,, 3}"))
+			var a []int
+			expect(dec.Decode(&a)).Equal(nil)
+			expect(dec.More()).Equal(true)
+			_, ok := dec.Decode(&a).(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+	})
+
+	describe("Decoder.Token", func() {
+		testcase := s.Alias("testcase")
+		tokens := func(dec *Decoder) (toks []Token, err error) {
+			for {
+				tok, err := dec.Token()
+				if err != nil {
+					return toks, err
+				}
+				toks = append(toks, tok)
+			}
+		}
+		testcase("all kinds of tokens", func() {
+			dec := NewDecoder(strings.NewReader("// c\n{a 1, b ^1 !int 2, \"q\"} /* x */\n{}"))
+			toks, err := tokens(dec)
+			expect(err).Equal(io.EOF)
+			expect(toks).Equal([]Token{
+				Comment("// c"), ListStart{}, Scalar("a"), Scalar("1"), Separator{},
+				Scalar("b"), Ref("1"), TypeTag("int"), Scalar("2"), Separator{},
+				Scalar(`"q"`), ListEnd{}, Comment("/* x */"), ListStart{}, ListEnd{},
+			})
+			s, err := toks[10].(Scalar).Unquote()
+			expect(err).Equal(nil)
+			expect(s).Equal("q")
+		})
+		testcase("decode list elements", func() {
+			dec := NewDecoder(strings.NewReader("{{1, 2}, // one\n {3}, {4},} {5}"))
+			tok, err := dec.Token()
+			expect(err).Equal(nil)
+			expect(tok).Equal(ListStart{})
+			var elems [][]int
+			for dec.More() {
+				var elem []int
+				expect(dec.Decode(&elem)).Equal(nil)
+				elems = append(elems, elem)
+			}
+			expect(elems).Equal([][]int{{1, 2}, {3}, {4}})
+			tok, err = dec.Token()
+			expect(err).Equal(nil)
+			expect(tok).Equal(ListEnd{})
+			var last []int
+			expect(dec.Decode(&last)).Equal(nil)
+			expect(last).Equal([]int{5})
+			expect(dec.More()).Equal(false)
+		})
+		testcase("unbalanced list end", func() {
+			_, err := tokens(NewDecoder(strings.NewReader("{a}}")))
+			_, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+		testcase("separator outside a list", func() {
+			toks, err := tokens(NewDecoder(strings.NewReader("a, b")))
+			expect(toks).Equal([]Token{Scalar("a")})
+			_, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+	})
+
+	describe("Unmarshal", func() {
+		testcase := s.Alias("testcase")
+		_encodingTestGroups.Test("decoding", s, func(tc encodingTestCase) {
+			nv := newValue(tc.value)
+			if nv.IsValid() {
+				err := Unmarshal([]byte(tc.text), nv.Interface())
+				expect(err).Equal(nil)
+				expect(nv.Elem().Interface()).Equal(tc.value)
+			}
+		})
+		testcase("trailing garbage", func() {
+			var i int
+			expect(Unmarshal([]byte("1 2"), &i)).NotEqual(nil)
+			var a []int
+			expect(Unmarshal([]byte("{1, 2} }"), &a)).NotEqual(nil)
+		})
+		testcase("syntax error position", func() {
+			var a []int
+			err := Unmarshal([]byte("{1,\n  2 x}"), &a)
+			se, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+			expect(se.Offset).Equal(int64(8))
+			expect(se.Line).Equal(2)
+			expect(se.Column).Equal(5)
+			expect(se.Token).Equal("x")
+			expect(se.Error()).Equal(`2:5: unexpected token "x"`)
+		})
+		testcase("syntax error at end of input", func() {
+			var a []int
+			err := Unmarshal([]byte("{1,"), &a)
+			se, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+			expect(se.Offset).Equal(int64(3))
+			expect(se.Token).Equal("")
+		})
+		testcase("scalar into list", func() {
+			var a []int
+			_, ok := Unmarshal([]byte("1"), &a).(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+		testcase("type error with field path", func() {
+			var v struct {
+				Servers []struct {
+					Port int
+				}
+			}
+			err := Unmarshal([]byte("{Servers {\n{Port 1},\n{Port 2},\n{Port x}}}"), &v)
+			te, ok := err.(*UnmarshalTypeError)
+			expect(ok).Equal(true)
+			expect(te.Value).Equal("x")
+			expect(te.Type).Equal(reflect.TypeOf(0))
+			expect(te.Field).Equal("Servers[2].Port")
+			expect(te.Line).Equal(4)
+			expect(te.Column).Equal(7)
+			expect(te.Error()).Equal(`4:7: cannot decode "x" into Go field Servers[2].Port of type int`)
+		})
+		testcase("type error in map", func() {
+			var m map[string][]bool
+			err := Unmarshal([]byte(`{"a" {true, 1}}`), &m)
+			te, ok := err.(*UnmarshalTypeError)
+			expect(ok).Equal(true)
+			expect(te.Field).Equal(`["a"][1]`)
+			expect(te.Type).Equal(reflect.TypeOf(true))
+		})
+		testcase("type error in string tagged field", func() {
+			var v struct {
+				I int `flow:",string"`
+			}
+			err := Unmarshal([]byte(`{I "x"}`), &v)
+			te, ok := err.(*UnmarshalTypeError)
+			expect(ok).Equal(true)
+			expect(te.Value).Equal("x")
+			expect(te.Field).Equal("I")
+			expect(te.Column).Equal(4)
+		})
+		testcase("numeric overflow", func() {
+			for _, tc := range []struct {
+				text string
+				v    interface{}
+			}{
+				{"128", new(int8)},
+				{"-129", new(int8)},
+				{"9223372036854775808", new(int64)},
+				{"256", new(uint8)},
+				{"-1", new(uint)},
+				{"18446744073709551616", new(uint64)},
+				{"1e40", new(float32)},
+				{"1e400", new(float64)},
+				{"1e40+0i", new(complex64)},
+				{"0+1e40i", new(complex64)},
+			} {
+				err := Unmarshal([]byte(tc.text), tc.v)
+				re, ok := err.(*RangeError)
+				expect(ok).Equal(true)
+				if ok {
+					expect(re.Value).Equal(tc.text)
+					expect(re.Type).Equal(reflect.TypeOf(tc.v).Elem())
+				}
+			}
+		})
+		testcase("numeric bounds", func() {
+			var (
+				i8  int8
+				u64 uint64
+				f32 float32
+			)
+			expect(Unmarshal([]byte("-128"), &i8)).Equal(nil)
+			expect(i8).Equal(int8(-128))
+			expect(Unmarshal([]byte("18446744073709551615"), &u64)).Equal(nil)
+			expect(u64).Equal(uint64(18446744073709551615))
+			expect(Unmarshal([]byte("3.4e38"), &f32)).Equal(nil)
+			expect(f32).Equal(float32(3.4e38))
+		})
+		testcase("range error with field path", func() {
+			var v struct {
+				Ports []uint16
+			}
+			err := Unmarshal([]byte("{Ports {80, 65536}}"), &v)
+			re, ok := err.(*RangeError)
+			expect(ok).Equal(true)
+			expect(re.Error()).Equal(`1:13: "65536" overflows Go field Ports[1] of type uint16`)
+		})
+		testcase("integer literals", func() {
+			for _, tc := range []struct {
+				text string
+				v    int64
+			}{
+				{"0x1F", 31},
+				{"0X1f", 31},
+				{"0o755", 493},
+				{"0755", 493},
+				{"0b1010", 10},
+				{"1_000_000", 1000000},
+				{"0x_ff_ff", 65535},
+				{"-0x10", -16},
+				{"+7", 7},
+			} {
+				var i int64
+				expect(Unmarshal([]byte(tc.text), &i)).Equal(nil)
+				expect(i).Equal(tc.v)
+				var u uint64
+				if tc.v >= 0 {
+					expect(Unmarshal([]byte(tc.text), &u)).Equal(nil)
+					expect(u).Equal(uint64(tc.v))
+				}
+			}
+			for _, text := range []string{"0x", "1__0", "_1", "1_", "0b102", "089"} {
+				var i int
+				_, ok := Unmarshal([]byte(text), &i).(*UnmarshalTypeError)
+				expect(ok).Equal(true)
+			}
+			var u8 uint8
+			_, ok := Unmarshal([]byte("0x100"), &u8).(*RangeError)
+			expect(ok).Equal(true)
+		})
+		testcase("NaN", func() {
+			var f float64
+			expect(Unmarshal([]byte("NaN"), &f)).Equal(nil)
+			expect(math.IsNaN(f)).Equal(true)
+			var c complex64
+			expect(Unmarshal([]byte("1+NaNi"), &c)).Equal(nil)
+			expect(math.IsNaN(float64(imag(c)))).Equal(true)
+		})
+		testcase("untyped values", func() {
+			for _, tc := range []struct {
+				text string
+				v    interface{}
+			}{
+				{"nil", nil},
+				{"true", true},
+				{"false", false},
+				{`"a b"`, "a b"},
+				{"a", "a"},
+				{"/usr/bin", "/usr/bin"},
+				{"2014-05-27T20:40:11Z", "2014-05-27T20:40:11Z"},
+				{"42", Number("42")},
+				{"-1.5e3", Number("-1.5e3")},
+				{"0x1F", Number("0x1F")},
+				{".5", Number(".5")},
+				{"1+2i", Number("1+2i")},
+				{"-Inf", Number("-Inf")},
+				{"inf", "inf"},
+				{"-", "-"},
+				{"{}", []interface{}{}},
+				{"{1, a}", []interface{}{Number("1"), "a"}},
+				{`{a 1, "b" {x, y}, c {d nil}}`, Map{
+					{"a", Number("1")},
+					{"b", []interface{}{"x", "y"}},
+					{"c", Map{{"d", nil}}},
+				}},
+				{"{{IKey 1} true}", Map{{Map{{"IKey", Number("1")}}, true}}},
+				{"{I !int 1, J !INT 2}", Map{{"I", 1}, {"J", INT(2)}}},
+				{"{I ^1 42, J ^1, K ^1}", Map{{"I", Number("42")}, {"J", Number("42")}, {"K", Number("42")}}},
+				{"{L ^1 {1, 2}, M ^1}", Map{
+					{"L", []interface{}{Number("1"), Number("2")}},
+					{"M", []interface{}{Number("1"), Number("2")}},
+				}},
+			} {
+				var v interface{}
+				expect(Unmarshal([]byte(tc.text), &v)).Equal(nil)
+				expect(v).Equal(tc.v)
+			}
+		})
+		testcase("untyped list with mixed elements", func() {
+			var v interface{}
+			_, ok := Unmarshal([]byte("{a, b 1}"), &v).(*SyntaxError)
+			expect(ok).Equal(true)
+			_, ok = Unmarshal([]byte("{a 1, b}"), &v).(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+		testcase("number methods", func() {
+			i, err := Number("0x10").Int64()
+			expect(err).Equal(nil)
+			expect(i).Equal(int64(16))
+			_, err = Number("1.5").Int64()
+			expect(err).NotEqual(nil)
+			f, err := Number("1.5").Float64()
+			expect(err).Equal(nil)
+			expect(f).Equal(1.5)
+			f, err = Number("-Inf").Float64()
+			expect(err).Equal(nil)
+			expect(f).Equal(math.Inf(-1))
+			for s, want := range map[Number]float64{"0x1F": 31, "-0o755": -493, "0b1010": 10, "1_000": 1000} {
+				f, err = s.Float64()
+				expect(err).Equal(nil)
+				expect(f).Equal(want)
+			}
+			_, err = Number("0x1" + strings.Repeat("0", 300)).Float64()
+			expect(err).NotEqual(nil)
+			buf, err := Marshal(map[Number]int{"0x1F": 1, "2": 2, "0b1": 3})
+			expect(err).Equal(nil)
+			expect(string(buf)).Equal("{0b1 3, 2 2, 0x1F 1}")
+		})
+		testcase("block comments", func() {
+			var a []int
+			expect(Unmarshal([]byte("/* list\n * of ints */ {1, /**/ 2 /* two */} /* end **/"), &a)).Equal(nil)
+			expect(a).Equal([]int{1, 2})
+			var str string
+			_, ok := Unmarshal([]byte("/*"), &str).(*SyntaxError)
+			expect(ok).Equal(true)
+			var m map[string]string
+			_, ok = Unmarshal([]byte("{a /*}"), &m).(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+		testcase("raw strings", func() {
+			var v struct{ A, B string }
+			expect(Unmarshal([]byte("{A `a\\n\n\"b\"`, B ``}"), &v)).Equal(nil)
+			expect(v.A).Equal("a\\n\n\"b\"")
+			expect(v.B).Equal("")
+		})
+		testcase("escape sequences", func() {
+			var a []string
+			expect(Unmarshal([]byte(`{"\a\b\f\n\r\t\v\\\"", "\101\x42\u0043\U00000044"}`), &a)).Equal(nil)
+			expect(a).Equal([]string{"\a\b\f\n\r\t\v\\\"", "ABCD"})
+		})
+		testcase("malformed escape sequences", func() {
+			for _, tc := range []struct {
+				text   string
+				column int
+			}{
+				{`{"ok", "a\q"}`, 10},
+				{`{"ok", "\x4"}`, 9},
+				{`{"ok", "\400"}`, 9},
+				{`{"ok", "a\"}`, 10},
+				{`{"ok", "\'"}`, 9},
+			} {
+				var a []string
+				err := Unmarshal([]byte(tc.text), &a)
+				se, ok := err.(*SyntaxError)
+				expect(ok).Equal(true)
+				if ok {
+					expect(se.Line).Equal(1)
+					expect(se.Column).Equal(tc.column)
+				}
+			}
+		})
+		testcase("trailing comment", func() {
+			var a []int
+			expect(Unmarshal([]byte("{1, 2} // end"), &a)).Equal(nil)
+			expect(a).Equal([]int{1, 2})
+		})
+	})
 })
@@ -0,0 +1,101 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A SyntaxError describes a token that is not valid at its place in the
+// OGDL flow text.
+type SyntaxError struct {
+	msg    string
+	Offset int64  // byte offset of the token in the input
+	Line   int    // 1-based line of the token
+	Column int    // 1-based byte column of the token
+	Token  string // the offending token, empty at the end of input
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.msg)
+}
+
+// An UnmarshalTypeError describes a value that cannot be decoded into the
+// Go type at its place in the OGDL flow text.
+type UnmarshalTypeError struct {
+	Value  string       // the value as written in the input
+	Type   reflect.Type // the Go type it could not be decoded into
+	Field  string       // path from the root value, e.g. Servers[2].Port
+	Offset int64        // byte offset of the value in the input
+	Line   int          // 1-based line of the value, 0 if unknown
+	Column int          // 1-based byte column of the value
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return e.format("cannot decode %s into %s of type %v")
+}
+
+func (e *UnmarshalTypeError) format(layout string) string {
+	where := "Go value"
+	if e.Field != "" {
+		where = "Go field " + e.Field
+	}
+	msg := fmt.Sprintf(layout, strconv.Quote(e.Value), where, e.Type)
+	if e.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, msg)
+	}
+	return msg
+}
+
+func (e *UnmarshalTypeError) base() *UnmarshalTypeError {
+	return e
+}
+
+// A RangeError describes a number that is out of the range of the Go type
+// it is decoded into.
+type RangeError struct {
+	UnmarshalTypeError
+}
+
+func (e *RangeError) Error() string {
+	return e.format("%s overflows %s of type %v")
+}
+
+// decodeError is implemented by the errors that are located by the decoder.
+type decodeError interface {
+	error
+	base() *UnmarshalTypeError
+}
+
+func typeError(val []byte, v reflect.Value) error {
+	return &UnmarshalTypeError{Value: string(val), Type: v.Type()}
+}
+
+func rangeError(val []byte, v reflect.Value) error {
+	return &RangeError{UnmarshalTypeError{Value: string(val), Type: v.Type()}}
+}
+
+// withField prepends a struct field name or an index like "[2]" to the
+// path of a decodeError, other errors are returned unchanged.
+func withField(err error, name string) error {
+	de, ok := err.(decodeError)
+	if !ok {
+		return err
+	}
+	e := de.base()
+	if e.Field == "" || strings.HasPrefix(e.Field, "[") {
+		e.Field = name + e.Field
+	} else {
+		e.Field = name + "." + e.Field
+	}
+	return err
+}
+
+func withIndex(err error, i int) error {
+	return withField(err, "["+strconv.Itoa(i)+"]")
+}
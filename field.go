@@ -0,0 +1,263 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field describes how a struct field is encoded and decoded, as controlled
+// by its `flow:"name,opt1,opt2"` struct tag. Fields of embedded structs are
+// promoted into the embedding struct with the same visibility rules as Go,
+// unless the embedded field is given a name in its tag.
+type field struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	quoted    bool
+}
+
+var (
+	fieldLock  sync.RWMutex
+	fieldCache = make(map[reflect.Type][]field)
+)
+
+func cachedFields(t reflect.Type) []field {
+	fieldLock.RLock()
+	fs, ok := fieldCache[t]
+	fieldLock.RUnlock()
+	if ok {
+		return fs
+	}
+	fs = typeFields(t)
+	fieldLock.Lock()
+	fieldCache[t] = fs
+	fieldLock.Unlock()
+	return fs
+}
+
+// typeFields walks t and its embedded structs breadth first, so that a
+// shallower field always shadows a deeper one of the same name.
+func typeFields(t reflect.Type) []field {
+	var (
+		current   []field
+		next      = []field{{typ: t}}
+		count     map[reflect.Type]int
+		nextCount = map[reflect.Type]int{}
+		visited   = map[reflect.Type]bool{}
+		fs        []field
+	)
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.PkgPath != "" { // unexported
+					// exported fields of an embedded unexported struct are
+					// still promoted, but a nil pointer to it cannot be
+					// allocated when decoding.
+					if !sf.Anonymous || sf.Type.Kind() != reflect.Struct {
+						continue
+					}
+				}
+				tag := sf.Tag.Get("flow")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				if !isValidTag(name) {
+					name = ""
+				}
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, field{name: ft.Name(), index: index, typ: ft})
+					}
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue
+				}
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+				}
+				fs = append(fs, field{
+					name:      name,
+					tagged:    tagged,
+					index:     index,
+					typ:       ft,
+					omitEmpty: opts.contains("omitempty"),
+					quoted:    opts.contains("string"),
+				})
+				if count[f.typ] > 1 {
+					// the same struct is embedded more than once at this
+					// depth, so the duplicate annihilates the field below.
+					fs = append(fs, fs[len(fs)-1])
+				}
+			}
+		}
+	}
+
+	sort.Slice(fs, func(i, j int) bool {
+		x, y := fs[i], fs[j]
+		if x.name != y.name {
+			return x.name < y.name
+		}
+		if len(x.index) != len(y.index) {
+			return len(x.index) < len(y.index)
+		}
+		if x.tagged != y.tagged {
+			return x.tagged
+		}
+		return indexLess(x.index, y.index)
+	})
+	out := fs[:0]
+	for i, n := 0, 0; i < len(fs); i += n {
+		for n = 1; i+n < len(fs) && fs[i+n].name == fs[i].name; n++ {
+		}
+		if f, ok := dominantField(fs[i : i+n]); ok {
+			out = append(out, f)
+		}
+	}
+	fs = out
+	sort.Slice(fs, func(i, j int) bool {
+		return indexLess(fs[i].index, fs[j].index)
+	})
+	return fs
+}
+
+// dominantField returns the field that shadows the others with the same
+// name, or false if the name is ambiguous. fs is sorted by depth and then
+// by whether the name comes from a tag.
+func dominantField(fs []field) (field, bool) {
+	if len(fs) > 1 && len(fs[0].index) == len(fs[1].index) && fs[0].tagged == fs[1].tagged {
+		return field{}, false
+	}
+	return fs[0], true
+}
+
+func indexLess(x, y []int) bool {
+	for i, xi := range x {
+		if i >= len(y) {
+			return false
+		}
+		if xi != y[i] {
+			return xi < y[i]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// fieldByIndex returns the field of struct v at index, or false if it is
+// unreachable through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// allocFieldByIndex is like fieldByIndex but allocates nil embedded pointers.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func fieldByName(fs []field, name string) (field, bool) {
+	for _, f := range fs {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+type tagOptions string
+
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+func (o tagOptions) contains(name string) bool {
+	for s := string(o); s != ""; {
+		var opt string
+		if i := strings.Index(s, ","); i != -1 {
+			opt, s = s[:i], s[i+1:]
+		} else {
+			opt, s = s, ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidTag reports whether name can be written as an unquoted key without
+// being mistaken for a delimiter, a reference or a type annotation.
+func isValidTag(name string) bool {
+	if name == "" || name[0] == '^' || name[0] == '!' {
+		return false
+	}
+	return !strings.ContainsAny(name, "\t\r\n {},\"")
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Complex64, reflect.Complex128:
+		return v.Complex() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
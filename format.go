@@ -0,0 +1,209 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Format returns the OGDL flow document src in canonical layout, which is
+// the layout of MarshalIndent with an indent of four spaces: a list is
+// written on one line if it fits within 80 bytes and one element per line
+// otherwise, with the values of keyed elements aligned. Comments, blank
+// lines between elements and the spelling of scalars are kept, and a list
+// with a comment or a blank line is always broken over lines.
+func Format(src []byte) ([]byte, error) {
+	doc, err := Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return formatNode(doc)
+}
+
+// formatNode writes a document in the canonical layout of Format.
+func formatNode(doc *Node) ([]byte, error) {
+	var buf bytes.Buffer
+	c := newComposer(&buf)
+	c.start("", "    ")
+	doc.formatDocument(&c)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// formatItem is a comment on its own line, or an element of a list or
+// document with the comment on the same line after it.
+type formatItem struct {
+	node     *Node
+	trailing *Node
+	blank    bool // preceded by a blank line
+}
+
+// formatItems groups the children of a list or document into items.
+func formatItems(children []*Node) []formatItem {
+	var items []formatItem
+	var prev *Node
+	for _, child := range children {
+		if child.inChain() {
+			// written in place by formatChain.
+			continue
+		}
+		if child.Kind == CommentNode && prev != nil && prev.Kind != CommentNode &&
+			items[len(items)-1].trailing == nil && sameLine(prev, child) {
+			items[len(items)-1].trailing = child
+		} else {
+			items = append(items, formatItem{node: child, blank: blankLine(prev, child)})
+		}
+		prev = child
+	}
+	return items
+}
+
+// between returns the source text between two nodes parsed one after
+// another, or nil if they are not.
+func between(prev, next *Node) []byte {
+	if prev == nil || prev.src == nil || next.src == nil || prev.end > next.start {
+		return nil
+	}
+	return prev.src[prev.end:next.start]
+}
+
+func sameLine(prev, next *Node) bool {
+	text := between(prev, next)
+	return text != nil && bytes.IndexByte(text, '\n') < 0
+}
+
+func blankLine(prev, next *Node) bool {
+	text := between(prev, next)
+	if text == nil {
+		return false
+	}
+	n := bytes.Count(text, []byte("\n"))
+	if prev.src[prev.end-1] == '\n' {
+		// a line comment ends with its line break.
+		n++
+	}
+	return n > 1
+}
+
+func (n *Node) formatDocument(c *composer) {
+	for i, item := range formatItems(n.Children) {
+		if item.blank && i > 0 {
+			c.newLine()
+		}
+		item.node.formatChain(c, 0)
+		if item.trailing != nil {
+			c.WriteString(" " + item.trailing.Text)
+		}
+		c.newLine()
+	}
+}
+
+func (n *Node) formatList(c *composer) {
+	children := n.Children
+	for len(children) > 0 && children[0].src != nil && children[0].end <= n.start {
+		// a comment before the list, written by formatValueComments.
+		children = children[1:]
+	}
+	items := formatItems(children)
+	if len(items) == 0 {
+		c.WriteString("{}")
+		return
+	}
+	keyMax, last := 0, -1
+	for i, item := range items {
+		if item.node.Kind == CommentNode {
+			continue
+		}
+		last = i
+		if keyMax >= 0 && item.node.Kind == ScalarNode && item.node.Value != nil {
+			if l := len(item.node.Text); l > keyMax {
+				keyMax = l
+			}
+		} else {
+			keyMax = -1
+		}
+	}
+	c.emit(layoutToken{kind: layoutGroupStart})
+	c.WriteString("{")
+	c.emit(layoutToken{kind: layoutNest})
+	for i, item := range items {
+		if item.blank && i > 0 {
+			c.emit(layoutToken{kind: layoutBreak})
+			c.emit(layoutToken{kind: layoutIfBreak, text: "\n"})
+		}
+		line := ""
+		if i > 0 {
+			line = " "
+		}
+		c.emit(layoutToken{kind: layoutLine, text: line})
+		if item.node.Kind == CommentNode {
+			c.WriteString(item.node.Text)
+			c.emit(layoutToken{kind: layoutBreak})
+			continue
+		}
+		item.node.formatChain(c, keyMax)
+		if i < last {
+			c.WriteString(",")
+		} else {
+			c.emit(layoutToken{kind: layoutIfBreak, text: ","})
+		}
+		if item.trailing != nil {
+			c.WriteString(" " + item.trailing.Text)
+			c.emit(layoutToken{kind: layoutBreak})
+		}
+	}
+	c.emit(layoutToken{kind: layoutUnnest})
+	c.emit(layoutToken{kind: layoutLine})
+	c.WriteString("}")
+	c.emit(layoutToken{kind: layoutGroupEnd})
+}
+
+// formatChain writes n and its Value chain, aligning the value after a key
+// to keyMax if it is positive.
+func (n *Node) formatChain(c *composer, keyMax int) {
+	for part := n; part != nil; part = part.Value {
+		switch part.Kind {
+		case ListNode:
+			part.formatList(c)
+		case RefNode:
+			c.WriteString("^" + part.Text)
+		case TypeNode:
+			c.WriteString("!" + part.Text)
+		default:
+			c.WriteString(part.Text)
+		}
+		if part.Value != nil {
+			c.WriteString(" ")
+			if part == n && keyMax > 0 {
+				c.pad(keyMax - len(n.Text))
+			}
+			part.formatValueComments(c)
+		}
+	}
+}
+
+// formatValueComments writes the comments between n and its value, which
+// break the list they are in.
+func (n *Node) formatValueComments(c *composer) {
+	if n.src == nil || n.Value.src == nil {
+		return
+	}
+	s := newScanner(bytes.NewReader(n.src[n.tokEnd:n.Value.start]))
+	for s.Scan() {
+		if tok := s.current(); tok.ID == tokenComment {
+			text := strings.TrimRight(string(tok.Value), "\r\n")
+			c.WriteString(text)
+			c.emit(layoutToken{kind: layoutBreak})
+			if strings.HasPrefix(text, "//") {
+				c.emit(layoutToken{kind: layoutLine})
+			} else {
+				c.WriteString(" ")
+			}
+		}
+	}
+}
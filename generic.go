@@ -0,0 +1,264 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Map is an ordered map, the value a list of key value pairs is decoded into
+// when the target is an untyped interface{}.
+type Map []MapItem
+
+// MapItem is a key value pair of a Map.
+type MapItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// Number is a numeral decoded into an untyped interface{}, kept in its
+// literal form so that no precision is lost.
+type Number string
+
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns the number as an integer.
+func (n Number) Int64() (int64, error) {
+	i, ok := parseInteger([]byte(n))
+	if !ok {
+		return 0, &strconv.NumError{Func: "Int64", Num: string(n), Err: strconv.ErrSyntax}
+	}
+	if !i.IsInt64() {
+		return 0, &strconv.NumError{Func: "Int64", Num: string(n), Err: strconv.ErrRange}
+	}
+	return i.Int64(), nil
+}
+
+// Float64 returns the number as a floating point value, rounded to the
+// nearest one for an integer in any of the literal forms of Int64.
+func (n Number) Float64() (float64, error) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil && !isRangeError(err) {
+		if i, ok := parseInteger([]byte(n)); ok {
+			f, _ = new(big.Float).SetInt(i).Float64()
+			if math.IsInf(f, 0) {
+				return f, &strconv.NumError{Func: "Float64", Num: string(n), Err: strconv.ErrRange}
+			}
+			return f, nil
+		}
+	}
+	return f, err
+}
+
+var (
+	mapType      = reflect.TypeOf(Map(nil))
+	numberType   = reflect.TypeOf(Number(""))
+	listType     = reflect.TypeOf([]interface{}(nil))
+	genericTypes = map[reflect.Type]bool{
+		numberType:            true,
+		reflect.TypeOf(""):    true,
+		reflect.TypeOf(false): true,
+		mapType:               true,
+		listType:              true,
+	}
+)
+
+// isGenericType reports whether values of t are what an interface{} is
+// decoded into without a type annotation.
+func isGenericType(t reflect.Type) bool {
+	return genericTypes[t]
+}
+
+func matchNumber(v reflect.Value) (*Encoding, bool) {
+	if v.Type() != numberType {
+		return nil, false
+	}
+	return ValueEncoding{encodeNumber, decodeNumber}.ToEncoding(v), true
+}
+
+func encodeNumber(v reflect.Value, w io.Writer) error {
+	if v.String() == "" {
+		return writeString(w, "0")
+	}
+	return writeString(w, v.String())
+}
+
+func decodeNumber(val []byte, v reflect.Value) error {
+	if !isNumber(string(val)) {
+		return typeError(val, v)
+	}
+	v.SetString(string(val))
+	return nil
+}
+
+func matchGenericMap(v reflect.Value) (*Encoding, bool) {
+	if v.Type() != mapType {
+		return nil, false
+	}
+	return &Encoding{encodeGenericMap(v), decodeGenericMap(v)}, true
+}
+
+func encodeGenericMap(v reflect.Value) EncodeFunc {
+	return func(c Composer) error {
+		if v.IsNil() {
+			return composeNil(c)
+		}
+		return c.ComposeList(v.Len(), func(i int) error {
+			item := v.Index(i)
+			if err := c.ComposeAny(item.Field(0)); err != nil {
+				return err
+			}
+			composeValue(c, " ")
+			return c.ComposeAny(item.Field(1))
+		})
+	}
+}
+
+func decodeGenericMap(v reflect.Value) DecodeFunc {
+	return func(parser Parser) error {
+		if isNil(parser) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		m := Map{}
+		err := parser.ParseList(func(i int) error {
+			var item MapItem
+			if err := parser.ParseAny(reflect.ValueOf(&item.Key)); err != nil {
+				return err
+			}
+			if err := parser.ParseAny(reflect.ValueOf(&item.Value)); err != nil {
+				return withIndex(err, i)
+			}
+			m = append(m, item)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(m))
+		return nil
+	}
+}
+
+func matchInterface(v reflect.Value) (*Encoding, bool) {
+	if v.Kind() != reflect.Interface || v.NumMethod() != 0 {
+		return nil, false
+	}
+	return &Encoding{nil, decodeInterface(v)}, true
+}
+
+// elemParser is implemented by parsers that can tell where a list element
+// ends, which is needed to tell a list of key value pairs from a plain list.
+type elemParser interface {
+	isSepOrListEnd() bool
+	error() error
+}
+
+// decodeInterface infers the value of an untyped interface{}: a list of key
+// value pairs is decoded into a Map, any other list into []interface{},
+// numerals into a Number, and nil, true, false and strings into themselves.
+func decodeInterface(v reflect.Value) DecodeFunc {
+	return func(parser Parser) error {
+		val, err := parser.Value()
+		if err != nil {
+			ep, ok := parser.(elemParser)
+			if !ok {
+				return err
+			}
+			return decodeGenericList(parser, ep, v)
+		}
+		switch s := string(val); {
+		case s == "nil":
+			v.Set(reflect.Zero(v.Type()))
+		case s == "true", s == "false":
+			v.Set(reflect.ValueOf(s == "true"))
+		case isNumber(s):
+			v.Set(reflect.ValueOf(Number(s)))
+		default:
+			var str string
+			if err := decodeString(val, reflect.ValueOf(&str).Elem()); err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(str))
+		}
+		return nil
+	}
+}
+
+func decodeGenericList(parser Parser, ep elemParser, v reflect.Value) error {
+	var (
+		list  = []interface{}{}
+		m     Map
+		isMap bool
+	)
+	err := parser.ParseList(func(i int) error {
+		var key interface{}
+		if err := parser.ParseAny(reflect.ValueOf(&key)); err != nil {
+			return withIndex(err, i)
+		}
+		pair := !ep.isSepOrListEnd()
+		if i == 0 {
+			isMap = pair
+		} else if pair != isMap {
+			return ep.error()
+		}
+		if !pair {
+			list = append(list, key)
+			return nil
+		}
+		var value interface{}
+		if err := parser.ParseAny(reflect.ValueOf(&value)); err != nil {
+			return withIndex(err, i)
+		}
+		m = append(m, MapItem{key, value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if isMap {
+		v.Set(reflect.ValueOf(m))
+	} else {
+		v.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+// isNumber reports whether s is an integer, floating point or complex
+// literal, NaN or a signed infinity.
+func isNumber(s string) bool {
+	switch s {
+	case "NaN", "Inf", "+Inf", "-Inf":
+		return true
+	}
+	d := s
+	if d != "" && (d[0] == '+' || d[0] == '-') {
+		d = d[1:]
+	}
+	if d == "" || d[0] != '.' && (d[0] < '0' || d[0] > '9') {
+		return false
+	}
+	if _, ok := parseInteger([]byte(s)); ok {
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil || isRangeError(err) {
+		return true
+	}
+	if _, err := strconv.ParseComplex(s, 128); err == nil || isRangeError(err) {
+		return true
+	}
+	return false
+}
+
+func isRangeError(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
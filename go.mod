@@ -4,6 +4,5 @@ go 1.17
 
 require (
 	github.com/davecgh/go-spew v1.1.1
-	h12.io/gombi v0.0.0-20180505161737-1db564f24381
 	h12.io/gspec v0.0.0-20180505161830-37536b8428fa
 )
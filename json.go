@@ -0,0 +1,418 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ToJSON converts the OGDL flow document src to JSON. The document is
+// mapped as Node.MarshalJSON describes.
+func ToJSON(src []byte) ([]byte, error) {
+	doc, err := Parse(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// FromJSON converts the JSON document src to an OGDL flow document in the
+// canonical layout of Format. The document is mapped as Node.UnmarshalJSON
+// describes.
+func FromJSON(src []byte) ([]byte, error) {
+	var doc Node
+	if err := json.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	return formatNode(&doc)
+}
+
+// MarshalJSON implements json.Marshaler. A non-empty list of key value
+// pairs is mapped to a JSON object and any other list to an array. A scalar is
+// mapped to null, true or false, to a number if it is a numeral, and to a
+// string otherwise; a numeral that JSON cannot represent, like NaN or a
+// complex number, is mapped to a string too.
+//
+// A use of a reference ^N is mapped to {"$ref": "N"}. A reference defined by
+// a value and a type annotation !T are added to the object of the value as
+// the members "$id": "N" and "$type": "T", or, if the value is not an object,
+// wrapped with it in an object as "$value". A key that is one of these four
+// names, or one of them with more "$" in front, is escaped with another "$",
+// and a key that is not a scalar is mapped to its OGDL flow text. Comments
+// are dropped.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if n.Kind == DocumentNode {
+		root := n.root()
+		if root == nil {
+			return []byte("null"), nil
+		}
+		n = root
+	}
+	if err := n.writeJSON(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSON writes n and its Value chain as a JSON value.
+func (n *Node) writeJSON(buf *bytes.Buffer) error {
+	var id, typ string
+	v := n
+	if v.Kind == RefNode && v.Value != nil {
+		id, v = v.Text, v.Value
+	}
+	if v.Kind == TypeNode && v.Value != nil {
+		typ, v = v.Text, v.Value
+	}
+	switch {
+	case v.Kind == RefNode && v.Value == nil:
+		if id != "" || typ != "" {
+			return fmt.Errorf("cannot convert a reference to a reference to JSON")
+		}
+		buf.WriteString(`{"$ref":`)
+		writeJSONString(buf, v.Text)
+		buf.WriteString("}")
+		return nil
+	case v.Kind != ScalarNode && v.Kind != ListNode || v.Value != nil:
+		return fmt.Errorf("cannot convert %s to JSON", strconv.Quote(n.flowText()))
+	case id == "" && typ == "":
+		return v.writeJSONValue(buf)
+	}
+	buf.WriteString("{")
+	if id != "" {
+		buf.WriteString(`"$id":`)
+		writeJSONString(buf, id)
+		buf.WriteString(",")
+	}
+	if typ != "" {
+		buf.WriteString(`"$type":`)
+		writeJSONString(buf, typ)
+		buf.WriteString(",")
+	}
+	if v.Kind == ListNode && v.isObject() {
+		if err := v.writeJSONMembers(buf); err != nil {
+			return err
+		}
+	} else {
+		buf.WriteString(`"$value":`)
+		if err := v.writeJSONValue(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+// writeJSONValue writes a scalar or a list as a JSON value.
+func (n *Node) writeJSONValue(buf *bytes.Buffer) error {
+	if n.Kind == ScalarNode {
+		return writeJSONScalar(buf, n.Text)
+	}
+	if n.isObject() {
+		buf.WriteString("{")
+		if err := n.writeJSONMembers(buf); err != nil {
+			return err
+		}
+		buf.WriteString("}")
+		return nil
+	}
+	buf.WriteString("[")
+	i := 0
+	for _, child := range n.Children {
+		if child.Kind == CommentNode {
+			continue
+		}
+		if child.isPair() {
+			return fmt.Errorf("cannot convert a list mixing key value pairs and values to JSON")
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		if err := child.writeJSON(buf); err != nil {
+			return err
+		}
+		i++
+	}
+	buf.WriteString("]")
+	return nil
+}
+
+// writeJSONMembers writes the pairs of a list as the members of an object.
+func (n *Node) writeJSONMembers(buf *bytes.Buffer) error {
+	i := 0
+	for _, child := range n.Children {
+		if child.Kind == CommentNode {
+			continue
+		}
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key := child.flowText()
+		if child.Kind == ScalarNode {
+			var err error
+			if key, err = unquote([]byte(child.Text)); err != nil {
+				return err
+			}
+		}
+		if isJSONEscaped("$" + key) {
+			key = "$" + key
+		}
+		writeJSONString(buf, key)
+		buf.WriteString(":")
+		if err := child.Value.writeJSON(buf); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
+
+// isObject reports whether n is a non-empty list of key value pairs.
+func (n *Node) isObject() bool {
+	pairs := 0
+	for _, child := range n.Children {
+		if child.Kind == CommentNode {
+			continue
+		}
+		if !child.isPair() {
+			return false
+		}
+		pairs++
+	}
+	return pairs > 0
+}
+
+// isPair reports whether the list element n is a key followed by a value.
+func (n *Node) isPair() bool {
+	return (n.Kind == ScalarNode || n.Kind == ListNode) && n.Value != nil
+}
+
+// flowText returns the OGDL flow text of n without its Value chain.
+func (n *Node) flowText() string {
+	m := *n
+	m.Value, m.src = nil, nil
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func writeJSONScalar(buf *bytes.Buffer, text string) error {
+	switch {
+	case text == "nil":
+		buf.WriteString("null")
+		return nil
+	case text == "true", text == "false":
+		buf.WriteString(text)
+		return nil
+	case isNumber(text):
+		if num, ok := jsonNumber(text); ok {
+			buf.WriteString(num)
+			return nil
+		}
+	}
+	s, err := unquote([]byte(text))
+	if err != nil {
+		return err
+	}
+	writeJSONString(buf, s)
+	return nil
+}
+
+// jsonNumber returns a numeral in JSON syntax, or false if JSON cannot
+// represent its value.
+func jsonNumber(s string) (string, bool) {
+	if json.Valid([]byte(s)) {
+		return s, true
+	}
+	if i, ok := parseInteger([]byte(s)); ok {
+		return i.String(), true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", false
+	}
+	s = strconv.FormatFloat(f, 'g', -1, 64)
+	return s, json.Valid([]byte(s))
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, setting n to the OGDL flow
+// document of a JSON value. It is the reverse of MarshalJSON: an object is
+// mapped to a list of key value pairs in the same order, with each key
+// unquoted where the syntax allows, an array to a list, a string to a
+// quoted string and null, true, false and numbers to themselves. An empty
+// object becomes an empty list like an empty array, so it is converted back
+// to an empty array. The "$ref",
+// "$id", "$type" and "$value" members are mapped back to references and type
+// annotations, and keys escaped by MarshalJSON are unescaped.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := jsonNode(dec)
+	if err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid data after the top-level JSON value")
+	}
+	*n = Node{Kind: DocumentNode, Children: []*Node{root}}
+	return nil
+}
+
+func jsonNode(dec *json.Decoder) (*Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return jsonObject(dec)
+		}
+		list := &Node{Kind: ListNode}
+		for dec.More() {
+			elem, err := jsonNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			list.Children = append(list.Children, elem)
+		}
+		_, err := dec.Token()
+		return list, err
+	case string:
+		return scalarNodeOf(quoteString(t)), nil
+	case json.Number:
+		return scalarNodeOf(string(t)), nil
+	case bool:
+		return scalarNodeOf(strconv.FormatBool(t)), nil
+	}
+	return scalarNodeOf("nil"), nil
+}
+
+func jsonObject(dec *json.Decoder) (*Node, error) {
+	var (
+		ref, id, typ string
+		value        *Node
+		list         = &Node{Kind: ListNode}
+	)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		var s *string
+		switch key {
+		case "$ref":
+			s = &ref
+		case "$id":
+			s = &id
+		case "$type":
+			s = &typ
+		}
+		if s != nil {
+			if tok, err = dec.Token(); err != nil {
+				return nil, err
+			}
+			if *s, _ = tok.(string); *s == "" {
+				return nil, fmt.Errorf("the value of %s is not a non-empty string", key)
+			}
+			continue
+		}
+		elem, err := jsonNode(dec)
+		if err != nil {
+			return nil, err
+		}
+		if key == "$value" {
+			value = elem
+			continue
+		}
+		if isJSONEscaped(key) {
+			key = key[1:]
+		}
+		k := scalarNodeOf(quoteString(key))
+		if IsBareString(key) {
+			k.Text = key
+		}
+		k.Value = elem
+		list.Children = append(list.Children, k)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	switch {
+	case ref != "":
+		if id != "" || typ != "" || value != nil || len(list.Children) > 0 {
+			return nil, fmt.Errorf("an object with $ref has other members")
+		}
+		return &Node{Kind: RefNode, Text: ref}, nil
+	case value != nil:
+		if len(list.Children) > 0 {
+			return nil, fmt.Errorf("an object with $value has other members")
+		}
+	default:
+		value = list
+	}
+	if typ != "" {
+		value = &Node{Kind: TypeNode, Text: typ, Value: value}
+	}
+	if id != "" {
+		value = &Node{Kind: RefNode, Text: id, Value: value}
+	}
+	return value, nil
+}
+
+// isJSONEscaped reports whether the JSON key is a key escaped by MarshalJSON
+// with a "$", which is one of the names of the members it adds with two or
+// more "$" in front.
+func isJSONEscaped(key string) bool {
+	name := strings.TrimLeft(key, "$")
+	switch {
+	case len(key)-len(name) < 2:
+		return false
+	case name == "ref", name == "id", name == "type", name == "value":
+		return true
+	}
+	return false
+}
+
+func scalarNodeOf(text string) *Node {
+	return &Node{Kind: ScalarNode, Text: text}
+}
+
+// IsBareString reports whether the string s can be written without quotes
+// in OGDL flow, which it cannot if the syntax requires them or if it would
+// then be read back as nil, a boolean or a number.
+func IsBareString(s string) bool {
+	switch {
+	case !isBareKey(s), s == "nil", s == "true", s == "false", isNumber(s):
+		return false
+	}
+	return true
+}
+
+// isBareKey reports whether key is a scalar without quotes in the syntax.
+func isBareKey(key string) bool {
+	if key == "" || strings.IndexByte("\"`^!", key[0]) >= 0 ||
+		strings.HasPrefix(key, "//") || strings.HasPrefix(key, "/*") {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isUnquoted(int(key[i])) {
+			return false
+		}
+	}
+	return true
+}
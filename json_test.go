@@ -0,0 +1,108 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"h12.io/gspec"
+)
+
+var jsonTestCases = []struct {
+	ogdl, json string
+}{
+	{"nil\n", `null`},
+	{"true\n", `true`},
+	{"1.5\n", `1.5`},
+	{"\"a b\"\n", `"a b"`},
+	{"{}\n", `[]`},
+	{"{1, \"x\", nil, false}\n", `[1,"x",null,false]`},
+	{"{name \"demo\", port 8080, hosts {\"a\", \"b\"}}\n",
+		`{"name":"demo","port":8080,"hosts":["a","b"]}`},
+	{"{\"a b\" 1, \"\" {}, $key 2, $$key 3, $ref 4, $$id 5}\n",
+		`{"a b":1,"":[],"$key":2,"$$key":3,"$$ref":4,"$$$id":5}`},
+	{"{\"nil\" 1, \"true\" 2, \"10\" 3, \"-1.5\" 4, nils 5}\n", `{"nil":1,"true":2,"10":3,"-1.5":4,"nils":5}`},
+	{"^1 {P ^1}\n", `{"$id":"1","P":{"$ref":"1"}}`},
+	{"{I !int 1, T !T {A 1}, L ^2 !list {1}}\n",
+		`{"I":{"$type":"int","$value":1},"T":{"$type":"T","A":1},"L":{"$id":"2","$type":"list","$value":[1]}}`},
+}
+
+var _ = gspec.Add(func(s gspec.S) {
+	describe, testcase := s.Alias("describe"), s.Alias("testcase")
+	expect := gspec.Expect(s.Fail)
+
+	describe("ToJSON", func() {
+		for _, tc := range jsonTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.ogdl), func() {
+				out, err := ToJSON([]byte(tc.ogdl))
+				expect(err).Equal(nil)
+				expect(string(out)).Equal(tc.json)
+			})
+		}
+		testcase("numerals", func() {
+			out, err := ToJSON([]byte("{0x1F, .5, +2, 1e3, NaN, 1i}"))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal(`[31,0.5,2,1e3,"NaN","1i"]`)
+		})
+		testcase("comments and keys", func() {
+			out, err := ToJSON([]byte("// doc\n{\"a\" 1, // one\n{k 1} 2, \"$\" 3}"))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal(`{"a":1,"{k 1}":2,"$":3}`)
+		})
+		testcase("errors", func() {
+			_, err := ToJSON([]byte("{a 1, b}"))
+			expect(err).NotEqual(nil)
+			_, err = ToJSON([]byte("{a"))
+			_, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+	})
+
+	describe("FromJSON", func() {
+		for _, tc := range jsonTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.json), func() {
+				out, err := FromJSON([]byte(tc.json))
+				expect(err).Equal(nil)
+				expect(string(out)).Equal(tc.ogdl)
+			})
+		}
+		testcase("object order and layout", func() {
+			out, err := FromJSON([]byte(`{"z": 1, "a": {"text": "line 1\nline 2", "list": []}}`))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal("{\n    z 1,\n    a {\n        text `line 1\nline 2`,\n        list {},\n    },\n}\n")
+		})
+		testcase("empty object", func() {
+			out, err := FromJSON([]byte(`{"a": {}}`))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal("{a {}}\n")
+			out, err = ToJSON(out)
+			expect(err).Equal(nil)
+			expect(string(out)).Equal(`{"a":[]}`)
+		})
+		testcase("dollar keys", func() {
+			src := `{"$schema":"x","$$a":1,"$$$b":2,"$$ref":3,"$$$value":4,"$":5,"a$":6}`
+			out, err := FromJSON([]byte(src))
+			expect(err).Equal(nil)
+			expect(string(out)).Equal("{$schema \"x\", $$a 1, $$$b 2, $ref 3, $$value 4, $ 5, a$ 6}\n")
+			out, err = ToJSON(out)
+			expect(err).Equal(nil)
+			expect(string(out)).Equal(src)
+		})
+		testcase("errors", func() {
+			for _, src := range []string{`{"a"`, `1 2`, `{"$ref": 1}`, `{"$ref": "1", "a": 1}`, `{"$value": 1, "a": 1}`} {
+				_, err := FromJSON([]byte(src))
+				expect(err).NotEqual(nil)
+			}
+		})
+		testcase("Node.UnmarshalJSON", func() {
+			var v struct{ Doc *Node }
+			expect(json.Unmarshal([]byte(`{"Doc": {"a": [1]}}`), &v)).Equal(nil)
+			expect(v.Doc.Get("a")).Equal(listNode(scalarNode("1")))
+		})
+	})
+})
@@ -0,0 +1,131 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"strings"
+)
+
+// defaultMaxWidth is the line width within which indented lists are kept on
+// one line unless changed with Encoder.SetMaxWidth.
+const defaultMaxWidth = 80
+
+type layoutKind int
+
+const (
+	layoutText       layoutKind = iota
+	layoutLine                  // a line break, or text if its group is on one line
+	layoutIfBreak               // text written only if its group is broken
+	layoutGroupStart            // start of a group of lines broken together
+	layoutGroupEnd
+	layoutNest // indents the lines that follow by one more level
+	layoutUnnest
+	layoutBreak // breaks the groups it is in, like a line comment
+)
+
+type layoutToken struct {
+	kind layoutKind
+	text string
+	flat bool // the group started fits on one line
+}
+
+// pendingGroup is a group whose layout is not decided yet.
+type pendingGroup struct {
+	index int // of its start in the buffer
+}
+
+// layout is a streaming pretty printer in the style of Wadler's "A prettier
+// printer": a group is written on one line if it fits within the maximum
+// width, and otherwise each line in it is broken and the groups in it are
+// decided in turn. Tokens are buffered only while the outermost undecided
+// group still fits, so the lookahead is bounded by the width.
+type layout struct {
+	buf     []layoutToken
+	pending []pendingGroup
+	width   int    // width of buf written on one line
+	broken  []bool // whether each group being written is broken
+}
+
+// flatWidth returns the width of tok written on one line, a text with a
+// line break never fits.
+func flatWidth(tok layoutToken) int {
+	switch tok.kind {
+	case layoutText, layoutLine:
+		if strings.IndexByte(tok.text, '\n') >= 0 {
+			return 1 << 30
+		}
+		return len(tok.text)
+	case layoutBreak:
+		return 1 << 30
+	}
+	return 0
+}
+
+// emit adds tok to the layout, writing what has been decided.
+func (t *composer) emit(tok layoutToken) {
+	l := &t.layout
+	if len(l.pending) == 0 && tok.kind != layoutGroupStart {
+		t.print(tok)
+		return
+	}
+	switch tok.kind {
+	case layoutGroupStart:
+		// a group emitted again after its outer group broke is decided anew.
+		tok.flat = false
+		l.pending = append(l.pending, pendingGroup{len(l.buf)})
+	case layoutGroupEnd:
+		g := l.pending[len(l.pending)-1]
+		l.pending = l.pending[:len(l.pending)-1]
+		l.buf[g.index].flat = true
+	default:
+		l.width += flatWidth(tok)
+	}
+	l.buf = append(l.buf, tok)
+	if len(l.pending) == 0 {
+		// the outermost group fits.
+		for _, tok := range l.buf {
+			t.print(tok)
+		}
+		l.buf, l.width = l.buf[:0], 0
+		return
+	}
+	if t.col+l.width > t.maxWidth {
+		// the outermost group is broken, decide the groups in it anew.
+		rest := append([]layoutToken(nil), l.buf[1:]...)
+		t.print(l.buf[0])
+		l.buf, l.pending, l.width = l.buf[:0], l.pending[:0], 0
+		for _, tok := range rest {
+			t.emit(tok)
+		}
+	}
+}
+
+// print writes a decided token.
+func (t *composer) print(tok layoutToken) {
+	l := &t.layout
+	broken := len(l.broken) == 0 || l.broken[len(l.broken)-1]
+	switch tok.kind {
+	case layoutText:
+		t.writeRaw(tok.text)
+	case layoutLine:
+		if broken {
+			t.newLine()
+		} else {
+			t.writeRaw(tok.text)
+		}
+	case layoutIfBreak:
+		if broken {
+			t.writeRaw(tok.text)
+		}
+	case layoutGroupStart:
+		l.broken = append(l.broken, !tok.flat)
+	case layoutGroupEnd:
+		l.broken = l.broken[:len(l.broken)-1]
+	case layoutNest:
+		t.depth++
+	case layoutUnnest:
+		t.depth--
+	}
+}
@@ -0,0 +1,674 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NodeKind identifies the syntactic element a Node stands for.
+type NodeKind int
+
+const (
+	DocumentNode NodeKind = iota + 1
+	ScalarNode
+	ListNode
+	RefNode
+	TypeNode
+	CommentNode
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case DocumentNode:
+		return "DocumentNode"
+	case ScalarNode:
+		return "ScalarNode"
+	case ListNode:
+		return "ListNode"
+	case RefNode:
+		return "RefNode"
+	case TypeNode:
+		return "TypeNode"
+	case CommentNode:
+		return "CommentNode"
+	}
+	return "node kind unknown"
+}
+
+// Node is an element of an OGDL flow document, parsed without mapping it to
+// Go values.
+//
+// A list element with several values, like a key and its value, is a chain
+// of nodes linked by Value. A RefNode or TypeNode is linked to the value it
+// defines or annotates, a RefNode without a Value is a use of the reference.
+// A comment between the values of an element, like {a /* x */ 1}, is a child
+// of the list after the element, but stays where it is when the document is
+// written after editing, and is dropped together with the element or with
+// the node it follows.
+type Node struct {
+	Kind NodeKind
+
+	// Text is the scalar as written including any quotes, the reference ID
+	// without "^", the type name without "!" or the comment including "//".
+	Text string
+
+	// Children are the elements and comments of a ListNode, or the root
+	// value and the comments around it of a DocumentNode.
+	Children []*Node
+
+	// Value is the node following this one in the same list element.
+	Value *Node
+
+	// src is the text the node was parsed from, nil for a new node. start
+	// and end delimit the node together with its Value chain, tokEnd is the
+	// end of its own token or closing brace.
+	src                []byte
+	start, end, tokEnd int
+	orig               origin
+}
+
+// origin is a node as it was parsed, to tell which nodes have been edited.
+type origin struct {
+	kind     NodeKind
+	text     string
+	children []*Node
+	value    *Node
+}
+
+// Parse reads a complete OGDL flow document from r. The returned node is a
+// DocumentNode.
+//
+// The nodes remember the text they are parsed from, so that writing the
+// document back reproduces the input byte for byte, and after editing it,
+// reproduces every part that has not been changed, including comments,
+// blank lines and the spelling of scalars.
+func Parse(r io.Reader) (*Node, error) {
+	var src bytes.Buffer
+	p := &nodeParser{parser: newParser(io.TeeReader(r, &src))}
+	doc, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	doc.end = src.Len()
+	doc.setOrigin(src.Bytes())
+	return doc, nil
+}
+
+// NewNode returns the node of the OGDL flow encoding of v.
+func NewNode(v interface{}) (*Node, error) {
+	buf, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := Parse(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	return doc.root(), nil
+}
+
+func (n *Node) setOrigin(src []byte) {
+	n.src = src
+	n.orig = origin{n.Kind, n.Text, append([]*Node(nil), n.Children...), n.Value}
+	for _, child := range n.Children {
+		child.setOrigin(src)
+	}
+	if n.Value != nil {
+		n.Value.setOrigin(src)
+	}
+}
+
+// Set replaces n and its Value chain with the encoding of v.
+func (n *Node) Set(v interface{}) error {
+	nn, err := NewNode(v)
+	if err != nil {
+		return err
+	}
+	n.Kind, n.Text, n.Children, n.Value = nn.Kind, nn.Text, nn.Children, nn.Value
+	return nil
+}
+
+// Index returns the index in Children of the element whose key is key, or
+// -1 if there is none. The key is the first scalar of the element, unquoted.
+// For a DocumentNode, the root value is searched.
+func (n *Node) Index(key string) int {
+	if n.Kind == DocumentNode {
+		if root := n.root(); root != nil {
+			return root.Index(key)
+		}
+		return -1
+	}
+	for i, child := range n.Children {
+		if child.Kind == ScalarNode && unquoteText(child.Text) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of the element whose key is key, or nil if there is
+// none.
+func (n *Node) Get(key string) *Node {
+	list := n
+	if n.Kind == DocumentNode {
+		if list = n.root(); list == nil {
+			return nil
+		}
+	}
+	if i := list.Index(key); i >= 0 {
+		return list.Children[i].Value
+	}
+	return nil
+}
+
+// Insert inserts child into Children at index i.
+func (n *Node) Insert(i int, child *Node) {
+	n.Children = append(n.Children, nil)
+	copy(n.Children[i+1:], n.Children[i:])
+	n.Children[i] = child
+}
+
+// Delete removes the child at index i from Children.
+func (n *Node) Delete(i int) {
+	n.Children = append(n.Children[:i:i], n.Children[i+1:]...)
+}
+
+func (n *Node) root() *Node {
+	for _, child := range n.Children {
+		if child.Kind != CommentNode {
+			return child
+		}
+	}
+	return nil
+}
+
+func unquoteText(text string) string {
+	if s, err := strconv.Unquote(text); err == nil {
+		return s
+	}
+	return text
+}
+
+// WriteTo writes n in OGDL flow syntax to w.
+func (n *Node) WriteTo(w io.Writer) (int64, error) {
+	c := newComposer(w)
+	if err := n.compose(&c); err != nil {
+		return c.n, err
+	}
+	return c.n, c.flush()
+}
+
+// edited reports whether n itself differs from the node as parsed.
+func (n *Node) edited() bool {
+	o := &n.orig
+	if n.src == nil || n.Kind != o.kind || n.Text != o.text || n.Value != o.value ||
+		len(n.Children) != len(o.children) {
+		return true
+	}
+	for i, child := range n.Children {
+		if child != o.children[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// changed reports whether n or any node below it has been edited.
+func (n *Node) changed() bool {
+	if n.edited() {
+		return true
+	}
+	for _, child := range n.Children {
+		if child.changed() {
+			return true
+		}
+	}
+	return n.Value != nil && n.Value.changed()
+}
+
+func (n *Node) compose(c *composer) error {
+	switch {
+	case n.src == nil:
+		return n.composeNew(c)
+	case !n.changed():
+		c.Write(n.src[n.start:n.end])
+		return nil
+	case !n.edited():
+		// only descendants are edited, keep the text between them.
+		pos := n.start
+		parts := n.Children
+		if n.Value != nil {
+			parts = append(parts[:len(parts):len(parts)], n.Value)
+		}
+		for _, part := range parts {
+			c.Write(n.src[pos:part.start])
+			if err := part.compose(c); err != nil {
+				return err
+			}
+			pos = part.end
+		}
+		c.Write(n.src[pos:n.end])
+		return nil
+	}
+	return n.composeEdited(c)
+}
+
+func (n *Node) composeNew(c *composer) error {
+	switch n.Kind {
+	case DocumentNode:
+		for _, child := range n.Children {
+			if err := child.compose(c); err != nil {
+				return err
+			}
+			if child.Kind != CommentNode {
+				c.newLine()
+			}
+		}
+		return nil
+	case ListNode:
+		if err := n.composeList(c); err != nil {
+			return err
+		}
+	case ScalarNode:
+		c.WriteString(n.Text)
+	case RefNode:
+		c.WriteString("^" + n.Text)
+	case TypeNode:
+		c.WriteString("!" + n.Text)
+	case CommentNode:
+		c.WriteString(n.Text)
+		c.newLine()
+		return nil
+	}
+	if n.Value != nil {
+		c.WriteString(" ")
+		return n.Value.compose(c)
+	}
+	return nil
+}
+
+// composeEdited writes a parsed node that has been edited, reusing the
+// original text between its parts where they are still adjacent.
+func (n *Node) composeEdited(c *composer) error {
+	switch n.Kind {
+	case DocumentNode:
+		return n.composeEditedChildren(c, 0, n.end, "")
+	case ListNode:
+		c.WriteString("{")
+		if err := n.composeEditedChildren(c, n.start+1, n.tokEnd-1, n.separator()); err != nil {
+			return err
+		}
+		c.WriteString("}")
+	case ScalarNode:
+		c.WriteString(n.Text)
+	case RefNode:
+		c.WriteString("^" + n.Text)
+	case TypeNode:
+		c.WriteString("!" + n.Text)
+	case CommentNode:
+		c.WriteString(n.Text)
+		if n.isLineComment() {
+			c.WriteString("\n")
+		}
+	}
+	if n.Value != nil {
+		if n.orig.value != nil {
+			// keep the layout and comments before a replaced value.
+			c.Write(n.src[n.tokEnd:n.orig.value.start])
+		} else {
+			c.WriteString(" ")
+		}
+		return n.Value.compose(c)
+	}
+	return nil
+}
+
+// composeEditedChildren writes the children of an edited list, whose
+// content originally spanned src[open:close], or of an edited document.
+// sep is the separator between two elements written one after another.
+func (n *Node) composeEditedChildren(c *composer, open, close int, sep string) error {
+	indent := ""
+	if i := strings.LastIndex(sep, "\n"); i >= 0 {
+		indent = sep[i+1:]
+	}
+	orig := n.orig.children
+	if n.orig.kind != n.Kind {
+		// the node was replaced by one of another kind
+		orig, open, close = nil, 0, 0
+	}
+	origIndex := func(child *Node) int {
+		for i, o := range orig {
+			if o == child {
+				return i
+			}
+		}
+		return -2
+	}
+	origStart := func(i int) int {
+		if i == 0 {
+			return open
+		}
+		return orig[i-1].end
+	}
+	kept := make(map[*Node]bool)
+	for _, child := range n.Children {
+		kept[child] = true
+	}
+	for i, o := range orig {
+		if o.inChain() && i > 0 && !kept[orig[i-1]] {
+			delete(kept, o)
+		}
+	}
+	var prev *Node
+	prevIndex := -1
+	needComma := false
+	for _, child := range n.Children {
+		if prev != nil && prev.isLineComment() && c.last != '\n' {
+			// a line comment at the end of the input has no line break.
+			c.WriteString("\n")
+		}
+		i := origIndex(child)
+		if child.inChain() {
+			// written with the element before it, if that is kept.
+			if i >= 0 {
+				prevIndex = i
+			}
+			continue
+		}
+		var gap string
+		switch {
+		case i > 0:
+			// an original child keeps the text that preceded it, or the
+			// line break of its deleted predecessor.
+			gap = string(n.src[orig[i-1].end:child.start])
+			if !kept[orig[i-1]] && !strings.Contains(gap, "\n") {
+				gap = string(n.src[origStart(i-1):orig[i-1].start])
+			}
+		case i == 0:
+			gap = string(n.src[open:child.start])
+		case n.Kind == DocumentNode:
+			if prev != nil && prev.Kind != CommentNode {
+				gap = "\n"
+			}
+		case prev == nil:
+			if strings.Contains(sep, "\n") {
+				gap = "\n" + indent
+			}
+		case prev.Kind == CommentNode:
+			gap = indent
+		case child.Kind == CommentNode:
+			gap = " "
+		default:
+			gap = sep
+		}
+		if n.Kind == ListNode {
+			gap = fixComma(gap, needComma, child.Kind != CommentNode)
+			if strings.Contains(gap, ",") {
+				needComma = false
+			}
+			if child.Kind != CommentNode {
+				needComma = true
+			}
+		}
+		c.WriteString(gap)
+		if err := child.compose(c); err != nil {
+			return err
+		}
+		prev, prevIndex = child, i
+	}
+	var tail string
+	switch {
+	case len(orig) == 0:
+		tail = string(n.src[open:close])
+	case prev != nil && prevIndex == len(orig)-1:
+		tail = string(n.src[orig[prevIndex].end:close])
+	case n.Kind == DocumentNode:
+		if prev != nil && prev.Kind != CommentNode {
+			tail = "\n"
+		}
+	default:
+		// keep the trailing comma and the line break before the closing
+		// brace of the original list.
+		last := len(orig) - 1
+		for last > 0 && orig[last].Kind == CommentNode {
+			last--
+		}
+		text := string(n.src[orig[last].end:close])
+		if j := strings.LastIndex(text, "\n"); j >= 0 {
+			tail = "\n" + text[j+1:]
+			if c.last == '\n' {
+				tail = tail[1:]
+			}
+		}
+		if needComma && strings.HasPrefix(strings.TrimLeft(text, " \t"), ",") {
+			tail = "," + tail
+		}
+		c.WriteString(tail)
+		return nil
+	}
+	if n.Kind == ListNode {
+		tail = fixComma(tail, needComma, false)
+	}
+	c.WriteString(tail)
+	return nil
+}
+
+// isLineComment reports whether n is a comment that ends with its line.
+func (n *Node) isLineComment() bool {
+	return n.Kind == CommentNode && strings.HasPrefix(n.Text, "//")
+}
+
+// inChain reports whether n is a comment between the values of an element,
+// which is written as part of the text between them.
+func (n *Node) inChain() bool {
+	return n.Kind == CommentNode && n.src != nil && n.start == n.end
+}
+
+// fixComma makes the text between two children of a list contain a comma
+// exactly when one is needed before the next element.
+func fixComma(gap string, needComma, beforeElem bool) string {
+	hasComma := strings.Contains(gap, ",")
+	switch {
+	case hasComma && !needComma:
+		return strings.Replace(gap, ",", "", 1)
+	case !hasComma && needComma && beforeElem:
+		if gap == "" {
+			return ", "
+		}
+		return "," + gap
+	}
+	return gap
+}
+
+// separator returns the text between two elements of a parsed list, which is
+// used to separate new elements in the same style.
+func (n *Node) separator() string {
+	orig := n.orig.children
+	if n.orig.kind != ListNode {
+		return ", "
+	}
+	for i := 1; i < len(orig); i++ {
+		if orig[i-1].Kind == CommentNode || orig[i].Kind == CommentNode {
+			continue
+		}
+		gap := string(n.src[orig[i-1].end:orig[i].start])
+		if j := strings.Index(gap, ","); j >= 0 {
+			return gap[j:]
+		}
+	}
+	if len(orig) > 0 {
+		if gap := string(n.src[n.start+1 : orig[0].start]); strings.Contains(gap, "\n") {
+			return "," + gap
+		}
+	}
+	return ", "
+}
+
+// composeList writes each comment together with the element that follows
+// it, and the comments after the last element together with that element.
+func (n *Node) composeList(c *composer) error {
+	var elems [][]*Node
+	var comments []*Node
+	for _, child := range n.Children {
+		if child.Kind == CommentNode {
+			comments = append(comments, child)
+			continue
+		}
+		elems = append(elems, append(comments, child))
+		comments = nil
+	}
+	if len(elems) == 0 {
+		c.WriteString("{")
+		for _, comment := range comments {
+			comment.composeNew(c)
+		}
+		c.WriteString("}")
+		return nil
+	}
+	last := len(elems) - 1
+	elems[last] = append(elems[last], comments...)
+	return c.ComposeList(len(elems), func(i int) error {
+		value := false
+		for _, child := range elems[i] {
+			if value && child.Kind == CommentNode {
+				c.WriteString(" ")
+			}
+			if err := child.compose(c); err != nil {
+				return err
+			}
+			value = child.Kind != CommentNode
+		}
+		return nil
+	})
+}
+
+// nodeParser builds a Node tree, collecting the comments that the parser
+// skips.
+type nodeParser struct {
+	*parser
+	comments []*Node
+}
+
+func (p *nodeParser) next() error {
+	for p.Scan() {
+		tok := p.current()
+		if tok.ID != tokenComment {
+			break
+		}
+		text := strings.TrimRight(string(tok.Value), "\r\n")
+		p.comments = append(p.comments, &Node{
+			Kind:   CommentNode,
+			Text:   text,
+			start:  p.pos.offset,
+			end:    p.end.offset,
+			tokEnd: p.end.offset,
+		})
+	}
+	return p.Error()
+}
+
+// flushComments moves the comments collected so far into n.
+func (p *nodeParser) flushComments(n *Node) {
+	n.Children = append(n.Children, p.comments...)
+	p.comments = nil
+}
+
+func (p *nodeParser) parseDocument() (*Node, error) {
+	doc := &Node{Kind: DocumentNode}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	p.flushComments(doc)
+	if p.isEOF() {
+		return doc, nil
+	}
+	root, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	doc.Children = append(doc.Children, root)
+	p.flushComments(doc)
+	if !p.isEOF() {
+		return nil, p.error()
+	}
+	return doc, nil
+}
+
+func (p *nodeParser) parseValue() (*Node, error) {
+	var n *Node
+	start := p.pos.offset
+	switch {
+	case p.isList():
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		n = list
+	case p.isRef():
+		n = &Node{Kind: RefNode, Text: string(p.current().Value[1:])}
+	case p.isType():
+		n = &Node{Kind: TypeNode, Text: string(p.current().Value[1:])}
+	case p.isValue():
+		n = &Node{Kind: ScalarNode, Text: string(p.current().Value)}
+	default:
+		return nil, p.error()
+	}
+	n.start, n.tokEnd = start, p.end.offset
+	n.end = n.tokEnd
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.isSepOrListEnd() || p.isEOF() {
+		if n.Kind == TypeNode {
+			return nil, p.error()
+		}
+		return n, nil
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	n.Value = value
+	n.end = value.end
+	for _, comment := range p.comments {
+		if comment.start < n.end {
+			// a comment between the values of an element is written with
+			// the text between them, see Node.
+			comment.start, comment.end, comment.tokEnd = n.end, n.end, n.end
+		}
+	}
+	return n, nil
+}
+
+// parseList parses a list and stops at its closing brace.
+func (p *nodeParser) parseList() (*Node, error) {
+	list := &Node{Kind: ListNode}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for {
+		p.flushComments(list)
+		if p.isListEnd() {
+			return list, nil
+		}
+		elem, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list.Children = append(list.Children, elem)
+		p.flushComments(list)
+		if p.isSep() {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if !p.isListEnd() {
+			return nil, p.error()
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"h12.io/gspec"
+)
+
+func scalarNode(text string) *Node {
+	return &Node{Kind: ScalarNode, Text: text}
+}
+
+func listNode(children ...*Node) *Node {
+	return &Node{Kind: ListNode, Children: children}
+}
+
+func commentNode(text string) *Node {
+	return &Node{Kind: CommentNode, Text: text}
+}
+
+// plain returns a copy of n without the layout of the parsed text.
+func plain(n *Node) *Node {
+	if n == nil {
+		return nil
+	}
+	p := &Node{Kind: n.Kind, Text: n.Text, Value: plain(n.Value)}
+	for _, child := range n.Children {
+		p.Children = append(p.Children, plain(child))
+	}
+	return p
+}
+
+func chain(nodes ...*Node) *Node {
+	for i := 0; i < len(nodes)-1; i++ {
+		nodes[i].Value = nodes[i+1]
+	}
+	return nodes[0]
+}
+
+var nodeTestCases = []struct {
+	text string
+	node *Node
+	out  string
+}{
+	{"", &Node{Kind: DocumentNode}, ""},
+	{"a", &Node{Kind: DocumentNode, Children: []*Node{scalarNode("a")}}, "a\n"},
+	{`"a b"`, &Node{Kind: DocumentNode, Children: []*Node{scalarNode(`"a b"`)}}, "\"a b\"\n"},
+	{"{a,b}", &Node{Kind: DocumentNode, Children: []*Node{
+		listNode(scalarNode("a"), scalarNode("b")),
+	}}, "{a, b}\n"},
+	{"{IVal 1, SVal \"a\",}", &Node{Kind: DocumentNode, Children: []*Node{
+		listNode(
+			chain(scalarNode("IVal"), scalarNode("1")),
+			chain(scalarNode("SVal"), scalarNode(`"a"`)),
+		),
+	}}, "{IVal 1, SVal \"a\"}\n"},
+	{"{{IKey 1} true}", &Node{Kind: DocumentNode, Children: []*Node{
+		listNode(chain(listNode(chain(scalarNode("IKey"), scalarNode("1"))), scalarNode("true"))),
+	}}, "{{IKey 1} true}\n"},
+	{"^1 {P ^1}", &Node{Kind: DocumentNode, Children: []*Node{
+		chain(&Node{Kind: RefNode, Text: "1"},
+			listNode(chain(scalarNode("P"), &Node{Kind: RefNode, Text: "1"}))),
+	}}, "^1 {P ^1}\n"},
+	{"{I !int 1}", &Node{Kind: DocumentNode, Children: []*Node{
+		listNode(chain(scalarNode("I"), &Node{Kind: TypeNode, Text: "int"}, scalarNode("1"))),
+	}}, "{I !int 1}\n"},
+	{"// head\n{ // first\na 1 // one\n, b 2, // two\n} // tail", &Node{Kind: DocumentNode, Children: []*Node{
+		commentNode("// head"),
+		listNode(
+			commentNode("// first"),
+			chain(scalarNode("a"), scalarNode("1")),
+			commentNode("// one"),
+			chain(scalarNode("b"), scalarNode("2")),
+			commentNode("// two"),
+		),
+		commentNode("// tail"),
+	}}, "// head\n{// first\na 1, // one\nb 2 // two\n}\n// tail\n"},
+	{"{// only\n}", &Node{Kind: DocumentNode, Children: []*Node{
+		listNode(commentNode("// only")),
+	}}, "{// only\n}\n"},
+}
+
+const editSource = `// config
+{
+    name   "demo", // the name
+
+    port   0x1F90,
+    hosts  {a,b},
+    // trailing
+}
+`
+
+func writeNode(n *Node) string {
+	var buf bytes.Buffer
+	n.WriteTo(&buf)
+	return buf.String()
+}
+
+var formatTestCases = []struct {
+	src, out string
+}{
+	{"", ""},
+	{"a", "a\n"},
+	{"{a,b}", "{a, b}\n"},
+	{"{\n  a   1,\n b {x,y},}\n", "{a 1, b {x, y}}\n"},
+	{"{IVal 0x1F, SVal `a`}", "{IVal 0x1F, SVal `a`}\n"},
+	{"^1 {P ^1, I !int 2}", "^1 {P ^1, I !int 2}\n"},
+	{"{// only\n}", "{\n    // only\n}\n"},
+	{"{a /* x */ 1, b // y\n 2}", "{\n    a /* x */ 1,\n    b // y\n    2,\n}\n"},
+	{editSource, `// config
+{
+    name  "demo", // the name
+
+    port  0x1F90,
+    hosts {a, b},
+    // trailing
+}
+`},
+	{"// head\n{ // first\na 1 // one\n, b 2, // two\n} // tail", `// head
+{
+    // first
+    a 1, // one
+    b 2, // two
+} // tail
+`},
+	{"{name \"" + strings.Repeat("a", 70) + "\", list {1, 2}, {x y} z}", `{
+    name "` + strings.Repeat("a", 70) + `",
+    list {1, 2},
+    {x y} z,
+}
+`},
+	{"{a {" + strings.Repeat("x, ", 30) + "x}, b {}}", "{\n    a {\n" +
+		strings.Repeat("        x,\n", 31) + "    },\n    b {},\n}\n"},
+	{"{\n\n a 1,\n\n\n b 2\n}", "{\n    a 1,\n\n    b 2,\n}\n"},
+	{"{a /* x */ 1, bb 2}", "{\n    a  /* x */ 1,\n    bb 2,\n}\n"},
+	{"{a // x\n 1, b /* y */ {c /* z */ d}}", `{
+    a // x
+    1,
+    b /* y */ {
+        c /* z */ d,
+    },
+}
+`},
+}
+
+var _ = gspec.Add(func(s gspec.S) {
+	describe, testcase := s.Alias("describe"), s.Alias("testcase")
+	expect := gspec.Expect(s.Fail)
+
+	describe("Parse", func() {
+		for _, tc := range nodeTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.text), func() {
+				n, err := Parse(strings.NewReader(tc.text))
+				expect(err).Equal(nil)
+				expect(plain(n)).Equal(tc.node)
+			})
+		}
+		for _, text := range []string{"{a", "{a b,, c}", "a}", "!int", "{!int}", "a, b"} {
+			text := text
+			testcase("syntax error "+strconv.Quote(text), func() {
+				_, err := Parse(strings.NewReader(text))
+				_, ok := err.(*SyntaxError)
+				expect(ok).Equal(true)
+			})
+		}
+	})
+
+	describe("Node.WriteTo", func() {
+		for _, tc := range nodeTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.text), func() {
+				var buf bytes.Buffer
+				n, err := tc.node.WriteTo(&buf)
+				expect(err).Equal(nil)
+				expect(buf.String()).Equal(tc.out)
+				expect(n).Equal(int64(len(tc.out)))
+				reparsed, err := Parse(&buf)
+				expect(err).Equal(nil)
+				expect(plain(reparsed)).Equal(tc.node)
+			})
+		}
+	})
+
+	describe("Format", func() {
+		for _, tc := range formatTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.src), func() {
+				out, err := Format([]byte(tc.src))
+				expect(err).Equal(nil)
+				expect(string(out)).Equal(tc.out)
+				again, err := Format(out)
+				expect(err).Equal(nil)
+				expect(string(again)).Equal(tc.out)
+			})
+		}
+		testcase("syntax error", func() {
+			_, err := Format([]byte("{a"))
+			_, ok := err.(*SyntaxError)
+			expect(ok).Equal(true)
+		})
+	})
+
+	describe("Node editing", func() {
+		doc, err := Parse(strings.NewReader(editSource))
+		expect(err).Equal(nil)
+		root := doc.Children[1]
+		testcase("unedited", func() {
+			expect(writeNode(doc)).Equal(editSource)
+		})
+		for _, text := range []string{"a", "  a  ", "{ a ,b,}", "// x", "{\n}\n// x"} {
+			text := text
+			testcase("unedited "+strconv.Quote(text), func() {
+				d, err := Parse(strings.NewReader(text))
+				expect(err).Equal(nil)
+				expect(writeNode(d)).Equal(text)
+			})
+		}
+		testcase("set a scalar", func() {
+			expect(doc.Get("port").Set(9090)).Equal(nil)
+			expect(writeNode(doc)).Equal(strings.Replace(editSource, "0x1F90", "9090", 1))
+		})
+		testcase("set text", func() {
+			doc.Get("name").Text = `"prod"`
+			expect(writeNode(doc)).Equal(strings.Replace(editSource, `"demo"`, `"prod"`, 1))
+		})
+		testcase("set a list", func() {
+			expect(doc.Get("port").Set([]int{1, 2})).Equal(nil)
+			expect(writeNode(doc)).Equal(strings.Replace(editSource, "0x1F90", "{1, 2}", 1))
+		})
+		testcase("insert into a nested list", func() {
+			hosts := doc.Get("hosts")
+			hosts.Insert(1, scalarNode("c"))
+			expect(writeNode(doc)).Equal(strings.Replace(editSource, "{a,b}", "{a,c,b}", 1))
+		})
+		testcase("insert an element", func() {
+			elem := chain(scalarNode("debug"), scalarNode("true"))
+			root.Insert(root.Index("hosts")+1, elem)
+			expect(writeNode(doc)).Equal(strings.Replace(editSource,
+				"{a,b},\n", "{a,b},\n    debug true,\n", 1))
+		})
+		testcase("append an element", func() {
+			elem, err := NewNode(map[string]int{"x": 1})
+			expect(err).Equal(nil)
+			root.Insert(len(root.Children), chain(scalarNode("extra"), elem))
+			expect(writeNode(doc)).Equal(strings.Replace(editSource,
+				"// trailing\n", "// trailing\n    extra {\"x\" 1},\n", 1))
+		})
+		testcase("delete an element", func() {
+			root.Delete(root.Index("port"))
+			expect(writeNode(doc)).Equal(strings.Replace(editSource,
+				"    port   0x1F90,\n", "", 1))
+		})
+		testcase("delete the first element", func() {
+			root.Delete(root.Index("name"))
+			expect(writeNode(doc)).Equal(strings.Replace(editSource,
+				`    name   "demo", //`, `    //`, 1))
+			reparsed, err := Parse(strings.NewReader(writeNode(doc)))
+			expect(err).Equal(nil)
+			expect(plain(reparsed)).Equal(plain(doc))
+		})
+		testcase("delete the last element", func() {
+			root.Delete(root.Index("hosts"))
+			root.Delete(len(root.Children) - 1)
+			expect(writeNode(doc)).Equal(`// config
+{
+    name   "demo", // the name
+
+    port   0x1F90,
+}
+`)
+		})
+		testcase("delete a comment", func() {
+			doc.Delete(0)
+			expect(writeNode(doc)).Equal(strings.TrimPrefix(editSource, "// config\n"))
+		})
+		testcase("insert into a compact list", func() {
+			d, err := Parse(strings.NewReader("{a 1, b 2} // end"))
+			expect(err).Equal(nil)
+			list := d.Children[0]
+			list.Insert(0, chain(scalarNode("z"), scalarNode("0")))
+			list.Insert(2, commentNode("// note"))
+			expect(writeNode(d)).Equal("{z 0, a 1 // note\n, b 2} // end")
+		})
+		testcase("comment inside an element", func() {
+			src := "{a /* x */ 1, b // y\n 2}"
+			edit := func(f func(list *Node)) string {
+				d, err := Parse(strings.NewReader(src))
+				expect(err).Equal(nil)
+				f(d.Children[0])
+				return writeNode(d)
+			}
+			expect(edit(func(list *Node) { list.Get("a").Set(3) })).Equal("{a /* x */ 3, b // y\n 2}")
+			expect(edit(func(list *Node) { list.Children[0].Text = "c" })).Equal("{c /* x */ 1, b // y\n 2}")
+			expect(edit(func(list *Node) { list.Insert(2, scalarNode("c")) })).Equal("{a /* x */ 1, c, b // y\n 2}")
+			expect(edit(func(list *Node) { list.Delete(0) })).Equal("{b // y\n 2}")
+			expect(edit(func(list *Node) { list.Delete(list.Index("b")) })).Equal("{a /* x */ 1}")
+			expect(edit(func(list *Node) { list.Children[0].Value = nil })).Equal("{a, b // y\n 2}")
+		})
+		testcase("block comments", func() {
+			src := "{\n    port 80,\n    /* hosts */\n    hosts {a, b},\n}"
+			edit := func(f func(list *Node)) string {
+				d, err := Parse(strings.NewReader(src))
+				expect(err).Equal(nil)
+				f(d.Children[0])
+				return writeNode(d)
+			}
+			expect(edit(func(list *Node) {
+				list.Insert(list.Index("port")+1, chain(scalarNode("debug"), scalarNode("true")))
+			})).Equal("{\n    port 80,\n    debug true,\n    /* hosts */\n    hosts {a, b},\n}")
+			expect(edit(func(list *Node) {
+				list.Children[1].Text = "/* all hosts */"
+				list.Delete(0)
+			})).Equal("{\n    /* all hosts */\n    hosts {a, b},\n}")
+		})
+	})
+})
@@ -5,9 +5,9 @@
 package flow
 
 import (
-	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 )
 
 type Parser interface {
@@ -28,7 +28,7 @@ func newParser(r io.Reader) *parser {
 
 func (t *parser) ParseList(parseElem func(int) error) error {
 	if !t.isList() {
-		return t.Error()
+		return t.error()
 	}
 	if err := t.GoToOnlyChild(); err != nil {
 		return err
@@ -60,7 +60,7 @@ func (t *parser) nextSibling() error {
 
 func (t *parser) next() error {
 	for t.Scan() {
-		if t.Token().ID == tokenComment {
+		if t.current().ID == tokenComment {
 			continue
 		} else {
 			break
@@ -70,15 +70,21 @@ func (t *parser) next() error {
 }
 
 func (t *parser) isType() bool {
-	return t.isValue() && len(t.Token().Value) > 0 && t.Token().Value[0] == '!'
+	return t.isText() && len(t.current().Value) > 0 && t.current().Value[0] == '!'
 }
 
 func (t *parser) isRef() bool {
-	return t.isValue() && len(t.Token().Value) > 0 && t.Token().Value[0] == '^'
+	return t.isText() && len(t.current().Value) > 0 && t.current().Value[0] == '^'
+}
+
+// isText reports whether the current token is a scalar as written in flow
+// syntax, which is not a typed scalar of the binary form.
+func (t *parser) isText() bool {
+	return t.isValue() && t.kind == binaryText
 }
 
 func (t *parser) isList() bool {
-	return t.Token().ID == tokenLeftBrace
+	return t.current().ID == tokenLeftBrace
 }
 
 func (t *parser) isNil() bool {
@@ -90,7 +96,11 @@ func (t *parser) isNil() bool {
 }
 
 func (t *parser) isListEnd() bool {
-	return t.Token().ID == tokenRightBrace
+	return t.current().ID == tokenRightBrace
+}
+
+func (t *parser) isEOF() bool {
+	return t.current().ID == tokenEOF
 }
 
 func (t *parser) isSepOrListEnd() bool {
@@ -98,21 +108,34 @@ func (t *parser) isSepOrListEnd() bool {
 }
 
 func (t *parser) isSep() bool {
-	return t.Token().ID == tokenComma
+	return t.current().ID == tokenComma
 }
 
 func (t *parser) isValue() bool {
-	return t.Token().ID == tokenString
+	return t.current().ID == tokenString
 }
 
 func (t *parser) Value() ([]byte, error) {
 	if !t.isValue() {
 		return nil, t.error()
 	}
-	return t.Token().Value, nil
+	if t.binary {
+		return t.binaryValue(), nil
+	}
+	return t.current().Value, nil
 }
 
 func (t *parser) error() error {
-	return fmt.Errorf("unexpected token: %v, %s", t.Token().ID,
-		string(t.Token().Value))
+	tok := t.current()
+	msg := "unexpected end of input"
+	if tok.ID != tokenEOF {
+		msg = "unexpected token " + strconv.Quote(string(tok.Value))
+	}
+	return &SyntaxError{
+		msg:    msg,
+		Offset: int64(t.pos.offset),
+		Line:   t.pos.line,
+		Column: t.pos.column,
+		Token:  string(tok.Value),
+	}
 }
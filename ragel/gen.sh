@@ -1,3 +0,0 @@
-#!/bin/sh
-
-ragel -G2 -Z -o ../scan_auto.go exec.rl
@@ -6,8 +6,7 @@ package flow
 
 import (
 	"io"
-
-	"h12.io/gombi/experiment/gre/scan"
+	"strconv"
 )
 
 const (
@@ -17,9 +16,13 @@ const (
 	tokenRightBrace
 	tokenComma
 	tokenString
-	tokenSpace
 )
 
+// tokenInvalid is the ID of a character that cannot start a token.
+const tokenInvalid = -1
+
+const minReadSize = 4096
+
 type tokenType int
 
 func (t tokenType) String() string {
@@ -40,58 +43,339 @@ func (t tokenType) String() string {
 	return "token unkown"
 }
 
+// token is a lexical token of the input. Value is a slice of the scanner's
+// buffer and is only valid until the next call to Scan.
+type token struct {
+	ID    int
+	Value []byte
+	Pos   int
+}
+
+// scanner splits OGDL flow text into tokens, skipping white space. It reads
+// the input through a buffer that is reused, so scanning a token does not
+// allocate.
 type scanner struct {
-	scan.Scanner
+	r    io.Reader
+	buf  []byte // buf[off:] is the input that has been read but not scanned
+	off  int
+	tok0 int   // buf[tok0:off] is the current token
+	rerr error // error of the last read, io.EOF at the end of input
+	err  error
+	done bool
+	tok  token
+	pos  position // position of the current token
+	end  position // position right after the current token
+
+	binary bool   // the input is in the binary form of BinaryEncoder
+	kind   byte   // kind of the current scalar in binary form
+	text   []byte // text of the current scalar if it is typed
+}
+
+// position is the location of a byte in the input, line and column are
+// 1-based and column counts bytes.
+type position struct {
+	offset int
+	line   int
+	column int
+}
+
+func (p position) advance(b []byte) position {
+	for _, c := range b {
+		p.offset++
+		if c == '\n' {
+			p.line++
+			p.column = 1
+		} else {
+			p.column++
+		}
+	}
+	return p
+}
+
+func newScanner(r io.Reader) *scanner {
+	return &scanner{
+		r:   r,
+		buf: make([]byte, 0, minReadSize),
+		end: position{0, 1, 1},
+	}
 }
 
+func (s *scanner) current() *token {
+	return &s.tok
+}
+
+func (s *scanner) Error() error {
+	return s.err
+}
+
+// Scan advances to the next token. At the end of input it yields a single
+// token of ID tokenEOF before returning false.
 func (s *scanner) Scan() bool {
-	for s.Scanner.Scan() {
-		if s.Token().ID != tokenSpace {
-			return true
+	if s.done || s.err != nil {
+		return false
+	}
+	if s.binary {
+		return s.scanBinary()
+	}
+	s.skipSpace()
+	s.pos = s.end
+	s.tok0 = s.off
+	c := s.peek(0)
+	if c < 0 {
+		if s.rerr != io.EOF {
+			s.err = s.rerr
+			return false
 		}
+		s.tok = token{ID: tokenEOF, Pos: s.pos.offset}
+		s.done = true
+		return true
+	}
+	id, n := tokenString, 0
+	switch c {
+	case '{':
+		id, n = tokenLeftBrace, 1
+	case '}':
+		id, n = tokenRightBrace, 1
+	case ',':
+		id, n = tokenComma, 1
+	case '/':
+		if n = s.scanComment(); n != 0 {
+			id = tokenComment
+		}
+	case '`':
+		n = s.scanRaw()
+	case '"':
+		n = s.scanQuoted()
+	}
+	if n == 0 {
+		// an unterminated quoted string is read as an unquoted string.
+		n = s.scanUnquoted()
+	}
+	if s.rerr != nil && s.rerr != io.EOF {
+		s.err = s.rerr
+		return false
+	}
+	if n < 0 {
+		return s.invalid("unterminated comment", 2)
+	}
+	if n == 0 {
+		return s.invalid("invalid character "+strconv.Quote(string(rune(c))), 1)
+	}
+	s.tok = token{ID: id, Value: s.buf[s.off : s.off+n], Pos: s.pos.offset}
+	s.off += n
+	s.end = s.pos.advance(s.tok.Value)
+	return true
+}
+
+// invalid stops scanning with a SyntaxError at an invalid token of n bytes.
+func (s *scanner) invalid(msg string, n int) bool {
+	s.tok = token{ID: tokenInvalid, Value: s.buf[s.off : s.off+n], Pos: s.pos.offset}
+	s.err = &SyntaxError{
+		msg:    msg,
+		Offset: int64(s.pos.offset),
+		Line:   s.pos.line,
+		Column: s.pos.column,
+		Token:  string(s.tok.Value),
 	}
 	return false
 }
 
-func newScanner(r io.Reader) *scanner {
-	var (
-		char  = scan.Char
-		pat   = scan.Pat
-		merge = scan.Merge
-		or    = scan.Or
-		con   = scan.Con
-
-		nonctrl   = char(`[:cntrl:]`).Negate()
-		indent    = char(`\t `)
-		lineBreak = char(`\n\r`)
-		space     = merge(indent, lineBreak)
-		any       = merge(nonctrl, space)
-		inline    = any.Exclude(lineBreak)
-		delim     = char(`,{}`)
-		empty     = pat(``)
-		//invalid   = any.Negate()
-
-		newline        = or(lineBreak, pat(`\r\n`))
-		inlineComment  = con(pat(`//`), inline.ZeroOrMore(), or(newline, empty))
-		quoted         = or(inline.Exclude(char(`"`)), pat(`\\"`))
-		quotedString   = con(pat(`"`), quoted.ZeroOrMore(), pat(`"`))
-		unquoted       = any.Exclude(delim, space)
-		unquotedString = unquoted.OneOrMore()
-		generalString  = or(quotedString, unquotedString)
-
-		matcher = scan.NewMatcher(
-			inlineComment,
-			char(`{`),
-			char(`}`),
-			char(`,`),
-			generalString,
-			space.OneOrMore(),
-		)
-	)
-	s := scan.Scanner{Matcher: matcher}
-	err := s.SetReader(r)
-	if err != nil {
-		panic(err)
-	}
-	return &scanner{s}
+// buffered returns the input that has been read but not scanned, including
+// the current token if withToken is true.
+func (s *scanner) buffered(withToken bool) []byte {
+	if withToken {
+		return s.buf[s.tok0:]
+	}
+	return s.buf[s.off:]
+}
+
+// peek returns the i-th byte of the unscanned input, or -1 if the input
+// ends before it.
+func (s *scanner) peek(i int) int {
+	if s.off+i < len(s.buf) {
+		return int(s.buf[s.off+i])
+	}
+	return s.peekMore(i)
+}
+
+func (s *scanner) peekMore(i int) int {
+	for s.off+i >= len(s.buf) {
+		if !s.fill() {
+			return -1
+		}
+	}
+	return int(s.buf[s.off+i])
+}
+
+// fill reads more input into buf, moving the unscanned input to its front
+// and growing it if needed.
+func (s *scanner) fill() bool {
+	if s.rerr != nil {
+		return false
+	}
+	if s.off > 0 {
+		n := copy(s.buf, s.buf[s.off:])
+		s.buf, s.off = s.buf[:n], 0
+	}
+	if cap(s.buf)-len(s.buf) < minReadSize {
+		buf := make([]byte, len(s.buf), 2*cap(s.buf)+minReadSize)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+	for {
+		n, err := s.r.Read(s.buf[len(s.buf):cap(s.buf)])
+		s.buf = s.buf[:len(s.buf)+n]
+		if err != nil {
+			s.rerr = err
+		}
+		if n > 0 || err != nil {
+			return n > 0
+		}
+	}
+}
+
+func (s *scanner) skipSpace() {
+	for {
+		switch s.peek(0) {
+		case '\n':
+			s.end.line++
+			s.end.column = 1
+		case ' ', '\t', '\r':
+			s.end.column++
+		default:
+			return
+		}
+		s.off++
+		s.end.offset++
+	}
+}
+
+// scanComment returns the length of a // or /* */ comment, 0 if there is
+// none, or -1 if the input ends inside a /* */ comment.
+func (s *scanner) scanComment() int {
+	switch s.peek(1) {
+	case '/':
+		i := 2
+		for isInline(s.peek(i)) {
+			i++
+		}
+		switch s.peek(i) {
+		case '\r':
+			i++
+			if s.peek(i) == '\n' {
+				i++
+			}
+		case '\n':
+			i++
+		}
+		return i
+	case '*':
+		for i := 2; ; i++ {
+			c := s.peek(i)
+			if c == '*' && s.peek(i+1) == '/' {
+				return i + 2
+			}
+			if c < 0 {
+				return -1
+			}
+			if !isAny(c) {
+				return 0
+			}
+		}
+	}
+	return 0
+}
+
+// scanRaw returns the length of a backtick quoted raw string, or 0 if there
+// is none.
+func (s *scanner) scanRaw() int {
+	for i := 1; ; i++ {
+		c := s.peek(i)
+		if c == '`' {
+			return i + 1
+		}
+		if !isAny(c) {
+			return 0
+		}
+	}
+}
+
+// scanQuoted returns the length of a double quoted string with valid escape
+// sequences, or 0 if there is none.
+func (s *scanner) scanQuoted() int {
+	for i := 1; ; {
+		c := s.peek(i)
+		switch {
+		case c == '"':
+			return i + 1
+		case c == '\\':
+			n := s.escapeLen(i + 1)
+			if n == 0 {
+				return 0
+			}
+			i += 1 + n
+		case isInline(c):
+			i++
+		default:
+			return 0
+		}
+	}
+}
+
+// escapeLen returns the length of an escape sequence after its backslash at
+// i, or 0 if it is not valid.
+func (s *scanner) escapeLen(i int) int {
+	var n int
+	var valid func(int) bool
+	switch c := s.peek(i); c {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '"':
+		return 1
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n, valid = 3, isOctal
+	case 'x':
+		n, valid = 3, isHex
+	case 'u':
+		n, valid = 5, isHex
+	case 'U':
+		n, valid = 9, isHex
+	default:
+		return 0
+	}
+	for j := 1; j < n; j++ {
+		if !valid(s.peek(i + j)) {
+			return 0
+		}
+	}
+	return n
+}
+
+func (s *scanner) scanUnquoted() int {
+	i := 0
+	for isUnquoted(s.peek(i)) {
+		i++
+	}
+	return i
+}
+
+// isAny reports whether c is allowed in comments and raw strings, which is
+// any byte but an ASCII control character other than white space.
+func isAny(c int) bool {
+	return c >= ' ' && c != 0x7f || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isInline is like isAny but excludes line breaks.
+func isInline(c int) bool {
+	return c >= ' ' && c != 0x7f || c == '\t'
+}
+
+func isUnquoted(c int) bool {
+	return c > ' ' && c != 0x7f && c != ',' && c != '{' && c != '}'
+}
+
+func isOctal(c int) bool {
+	return '0' <= c && c <= '7'
+}
+
+func isHex(c int) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
 }
@@ -5,14 +5,15 @@
 package flow
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/davecgh/go-spew/spew"
-	"h12.io/gombi/experiment/gre/scan"
 	"h12.io/gspec"
 )
 
@@ -20,33 +21,33 @@ const EOF = 0
 
 type testCase struct {
 	text   string
-	tokens []*scan.Token
+	tokens []*token
 }
 
 var testCases = []testCase{
 	{
 		"a",
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte("a"), 0},
 		},
 	},
 	{
 		"a b",
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte("a"), 0},
 			{tokenString, []byte("b"), 2},
 		},
 	},
 	{
 		"{}",
-		[]*scan.Token{
+		[]*token{
 			{tokenLeftBrace, []byte("{"), 0},
 			{tokenRightBrace, []byte("}"), 1},
 		},
 	},
 	{
 		"{a}",
-		[]*scan.Token{
+		[]*token{
 			{tokenLeftBrace, []byte("{"), 0},
 			{tokenString, []byte("a"), 1},
 			{tokenRightBrace, []byte("}"), 2},
@@ -54,7 +55,7 @@ var testCases = []testCase{
 	},
 	{
 		"{a, b}",
-		[]*scan.Token{
+		[]*token{
 			{tokenLeftBrace, []byte("{"), 0},
 			{tokenString, []byte("a"), 1},
 			{tokenComma, []byte(","), 2},
@@ -64,7 +65,7 @@ var testCases = []testCase{
 	},
 	{
 		"{a,}",
-		[]*scan.Token{
+		[]*token{
 			{tokenLeftBrace, []byte("{"), 0},
 			{tokenString, []byte("a"), 1},
 			{tokenComma, []byte(","), 2},
@@ -73,7 +74,7 @@ var testCases = []testCase{
 	},
 	{
 		"a{b}",
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte("a"), 0},
 			{tokenLeftBrace, []byte("{"), 1},
 			{tokenString, []byte("b"), 2},
@@ -82,28 +83,28 @@ var testCases = []testCase{
 	},
 	{
 		`"a"`,
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte(`"a"`), 0},
 		},
 	},
 	/*
 		{
 			"a:",
-			[]*scan.Token{
+			[]*token{
 				{tokenString, 0, []byte("a")},
 				{tokenString, 1, []byte(":")},
 			},
 		},
 		{
 			`"a":`,
-			[]*scan.Token{
+			[]*token{
 				{tokenString, 0, []byte(`"a"`)},
 				{tokenString, 3, []byte(`:`)},
 			},
 		},
 		{
 			`{a}:`,
-			[]*scan.Token{
+			[]*token{
 				{tokenLeftBrace, 0, []byte("{")},
 				{tokenString, 1, []byte("a")},
 				{tokenRightBrace, 2, []byte("}")},
@@ -113,17 +114,49 @@ var testCases = []testCase{
 	*/
 	{
 		"/usr/bin",
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte("/usr/bin"), 0},
 		},
 	},
 	{
 		"a //0123",
-		[]*scan.Token{
+		[]*token{
 			{tokenString, []byte("a"), 0},
 			{tokenComment, []byte("//0123"), 2},
 		},
 	},
+	{
+		`"C:\\" "\\\"a" "\x41\u00e9"`,
+		[]*token{
+			{tokenString, []byte(`"C:\\"`), 0},
+			{tokenString, []byte(`"\\\"a"`), 7},
+			{tokenString, []byte(`"\x41\u00e9"`), 15},
+		},
+	},
+	{
+		"a //0\r\nb",
+		[]*token{
+			{tokenString, []byte("a"), 0},
+			{tokenComment, []byte("//0\r\n"), 2},
+			{tokenString, []byte("b"), 7},
+		},
+	},
+	{
+		"a /* 0\n*1* */b",
+		[]*token{
+			{tokenString, []byte("a"), 0},
+			{tokenComment, []byte("/* 0\n*1* */"), 2},
+			{tokenString, []byte("b"), 13},
+		},
+	},
+	{
+		"{`a\n\\b`}",
+		[]*token{
+			{tokenLeftBrace, []byte("{"), 0},
+			{tokenString, []byte("`a\n\\b`"), 1},
+			{tokenRightBrace, []byte("}"), 7},
+		},
+	},
 }
 
 var _ = gspec.Add(func(s gspec.S) {
@@ -139,18 +172,130 @@ var _ = gspec.Add(func(s gspec.S) {
 				tokens, eof := tokens[:len(tokens)-1], tokens[len(tokens)-1]
 				expect(tokens).Equal(tc.tokens)
 				expect(eof.ID).Equal(EOF)
+
+				s = newScanner(iotest.OneByteReader(strings.NewReader(tc.text)))
+				expect(s.scanAll()[:len(tokens)]).Equal(tc.tokens)
 			})
 		}
+		testcase := s.Alias("testcase")
+		testcase("token longer than the buffer", func() {
+			long := strings.Repeat("a", 3*minReadSize)
+			s := newScanner(strings.NewReader("{" + long + ", b}"))
+			tokens := s.scanAll()
+			expect(len(tokens)).Equal(6)
+			expect(string(tokens[1].Value)).Equal(long)
+			expect(string(tokens[3].Value)).Equal("b")
+		})
+		testcase("unterminated quotes", func() {
+			s := newScanner(strings.NewReader("`a \"b"))
+			tokens := s.scanAll()
+			expect(len(tokens)).Equal(3)
+			expect(tokens[0]).Equal(&token{tokenString, []byte("`a"), 0})
+			expect(tokens[1]).Equal(&token{tokenString, []byte("\"b"), 3})
+		})
+		testcase("unterminated comment", func() {
+			s := newScanner(strings.NewReader("{a\n /* b *"))
+			expect(len(s.scanAll())).Equal(2)
+			se, ok := s.Error().(*SyntaxError)
+			expect(ok).Equal(true)
+			if ok {
+				expect(se.Error()).Equal("2:2: unterminated comment")
+				expect(se.Offset).Equal(int64(4))
+				expect(se.Token).Equal("/*")
+			}
+		})
+		testcase("invalid character", func() {
+			s := newScanner(strings.NewReader("a\n \x01"))
+			expect(s.Scan()).Equal(true)
+			expect(s.Scan()).Equal(false)
+			se, ok := s.Error().(*SyntaxError)
+			expect(ok).Equal(true)
+			if ok {
+				expect(se.Line).Equal(2)
+				expect(se.Column).Equal(2)
+			}
+		})
+		testcase("read error", func() {
+			s := newScanner(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("ab"))))
+			expect(s.Scan()).Equal(false)
+			expect(s.Error()).Equal(iotest.ErrTimeout)
+		})
 	})
 })
 
-func (s *scanner) scanAll() (tokens []*scan.Token) {
+func (s *scanner) scanAll() (tokens []*token) {
 	for s.Scan() {
-		tokens = append(tokens, s.Token())
+		tok := *s.current()
+		tok.Value = append([]byte(nil), tok.Value...)
+		tokens = append(tokens, &tok)
 	}
 	return
 }
 
+// benchDocument returns an OGDL flow document of about size bytes.
+func benchDocument(size int) []byte {
+	type record struct {
+		ID      int
+		Name    string
+		Tags    []string
+		Score   float64
+		Enabled bool
+		Attrs   map[string]string
+	}
+	var records []record
+	var doc []byte
+	for n := 100; len(doc) < size; n *= 2 {
+		for i := len(records); i < n; i++ {
+			records = append(records, record{
+				ID:      i,
+				Name:    fmt.Sprintf("record \"%d\"", i),
+				Tags:    []string{"alpha", "beta", "gamma"},
+				Score:   float64(i) / 7,
+				Enabled: i%2 == 0,
+				Attrs:   map[string]string{"path": "/var/lib/ogdl"},
+			})
+		}
+		doc, _ = MarshalIndent(records, "", "    ")
+	}
+	return doc
+}
+
+// BenchmarkScan and BenchmarkUnmarshal on go1.27.1 linux/amd64, one core,
+// -benchtime 20x -count 3:
+//
+//	BenchmarkScan       29-34 ms/op   161-187 MB/s  16432 B/op        3 allocs/op
+//	BenchmarkUnmarshal  394-635 ms/op  8.6-13.9 MB/s  80.5 MB/op  3430393 allocs/op
+//
+// The gombi scanner this one replaced cannot be benchmarked side by side:
+// h12.io/gombi is no longer served by the module proxy (403 Forbidden).
+func BenchmarkScan(b *testing.B) {
+	doc := benchDocument(4 << 20)
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := newScanner(bytes.NewReader(doc))
+		for s.Scan() {
+		}
+		if s.Error() != nil {
+			b.Fatal(s.Error())
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	doc := benchDocument(4 << 20)
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var v interface{}
+		if err := Unmarshal(doc, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestAll(t *testing.T) {
 	//	for i := 0; i < 500; i++ {
 	gspec.Test(t)
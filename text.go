@@ -0,0 +1,536 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ParseText reads a complete document in the indentation based OGDL 2.0
+// text syntax from r and returns it as the DocumentNode of the equivalent
+// OGDL flow document.
+//
+// In the text syntax, each word on a line is a child of the word before it.
+// A comma makes the next word a sibling of the word after the first of the
+// line, or after "(" in a group, and after ")" the next word is again a
+// child of the word before "(". The words of a more indented line are
+// children of the word that the next word of the less indented line above
+// would be a child of. A word is a run of characters other than spaces,
+// commas and parentheses, a string in single or double quotes, or a block
+// of the more indented lines after a "\" that ends a line. "#" starts a
+// comment at the start of a line or after a space.
+//
+// The root of the document is the list of the words that start a line
+// without indentation. A word with no children is a scalar, a word with a
+// single child that is a scalar, a reference or a type annotation is a key
+// followed by that value, and a word with other children is a key followed
+// by the list of them. A word followed by a group is always a key followed
+// by a list, so that "a (b)" is a list of the single scalar b, unlike "a b",
+// and "a ()" is an empty list. Unquoted words are read as in OGDL flow
+// syntax, so that they can be numbers, nil, true, false, references ^ID and
+// type annotations !T, and quoted words are always strings.
+func ParseText(r io.Reader) (*Node, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &textParser{src: src}
+	root := &textNode{}
+	if err := p.parse(root); err != nil {
+		return nil, err
+	}
+	list := &Node{Kind: ListNode}
+	for _, child := range root.children {
+		list.Children = append(list.Children, child.node())
+	}
+	return &Node{Kind: DocumentNode, Children: []*Node{list}}, nil
+}
+
+// UnmarshalText parses a document in the OGDL 2.0 text syntax, as ParseText
+// describes, and stores the result in the value pointed to by v like
+// Unmarshal does for the same document in flow syntax.
+func UnmarshalText(data []byte, v interface{}) error {
+	doc, err := ParseText(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return err
+	}
+	return Unmarshal(buf.Bytes(), v)
+}
+
+// MarshalText returns the encoding of v in the OGDL 2.0 text syntax. v must
+// be encoded as a list, and a list in a list must have a key.
+func MarshalText(v interface{}) ([]byte, error) {
+	n, err := NewNode(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err := n.WriteText(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteText writes the list n, or the list at the root of the DocumentNode
+// n, in the OGDL 2.0 text syntax to w, indented by two spaces. It is the
+// reverse of ParseText. Comments are not written, and an empty list or a
+// list of a single element that would be read back as a value is written as
+// a group.
+func (n *Node) WriteText(w io.Writer) (int64, error) {
+	if n.Kind == DocumentNode {
+		if n = n.root(); n == nil {
+			return 0, nil
+		}
+	}
+	if n.Kind != ListNode || n.Value != nil {
+		return 0, fmt.Errorf("the root of OGDL text must be a list")
+	}
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	err := writeTextElems(cw, n, 0)
+	if err == nil {
+		err = bw.Flush()
+	}
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func writeTextElems(w io.Writer, list *Node, depth int) error {
+	for _, elem := range list.Children {
+		if elem.Kind == CommentNode {
+			continue
+		}
+		if elem.Kind == ListNode {
+			return fmt.Errorf("cannot write a list without a key as OGDL text")
+		}
+		if err := writeTextElem(w, elem, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTextElem writes a list element and its Value chain as a line, and
+// the elements of a list at the end of the chain as the lines below it.
+func writeTextElem(w io.Writer, elem *Node, depth int) error {
+	words, list, err := textWords(elem, false)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth), strings.Join(words, " ")); err != nil {
+		return err
+	}
+	if list != nil {
+		return writeTextElems(w, list, depth+1)
+	}
+	return nil
+}
+
+// textWords returns the words of a list element and its Value chain, and
+// the list at the end of the chain that is left to be written as lines. A
+// list is written as a group if it is empty, if inline is true, or if it has
+// a single element that would otherwise be read back as the value of the key
+// before it.
+func textWords(elem *Node, inline bool) (words []string, list *Node, err error) {
+	for n := elem; n != nil; n = n.Value {
+		switch n.Kind {
+		case RefNode:
+			words = append(words, "^"+n.Text)
+		case TypeNode:
+			words = append(words, "!"+n.Text)
+		case ScalarNode:
+			words = append(words, textWord(n.Text))
+		case ListNode:
+			if n.Value != nil {
+				return nil, nil, fmt.Errorf("cannot write a list as a key in OGDL text")
+			}
+			single := singleElem(n)
+			if !inline && !isEmptyList(n) && (single == nil || single.Kind == ScalarNode && single.Value != nil) {
+				return words, n, nil
+			}
+			group, err := textGroup(n)
+			if err != nil {
+				return nil, nil, err
+			}
+			words = append(words, group)
+		}
+	}
+	return words, nil, nil
+}
+
+// textGroup returns the list n written as a group on one line.
+func textGroup(n *Node) (string, error) {
+	var elems []string
+	for _, elem := range n.Children {
+		if elem.Kind == CommentNode {
+			continue
+		}
+		if elem.Kind == ListNode {
+			return "", fmt.Errorf("cannot write a list without a key as OGDL text")
+		}
+		words, _, err := textWords(elem, true)
+		if err != nil {
+			return "", err
+		}
+		elems = append(elems, strings.Join(words, " "))
+	}
+	return "(" + strings.Join(elems, ", ") + ")", nil
+}
+
+// singleElem returns the only element of the list n, or nil if it has
+// another number of elements.
+func singleElem(n *Node) *Node {
+	var elem *Node
+	for _, child := range n.Children {
+		if child.Kind == CommentNode {
+			continue
+		}
+		if elem != nil {
+			return nil
+		}
+		elem = child
+	}
+	return elem
+}
+
+func isEmptyList(n *Node) bool {
+	for _, child := range n.Children {
+		if child.Kind != CommentNode {
+			return false
+		}
+	}
+	return true
+}
+
+// textWord returns the word of an OGDL flow scalar, which is quoted if it is
+// a string that would not be read back as a string without quotes.
+func textWord(text string) string {
+	if text != "" && (text[0] == '"' || text[0] == '`') {
+		s := unquoteText(text)
+		switch {
+		case !isTextBare(s) || !IsBareString(s):
+		default:
+			return s
+		}
+		return strconv.Quote(s)
+	}
+	if isTextBare(text) {
+		return text
+	}
+	return strconv.Quote(text)
+}
+
+// isTextBare reports whether s can be written as a word without quotes.
+func isTextBare(s string) bool {
+	if s == "" || s == `\` || strings.IndexByte("#'\"^!", s[0]) >= 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == 0x7f || c == ',' || c == '(' || c == ')' {
+			return false
+		}
+	}
+	return true
+}
+
+// textNode is a node of the graph of an OGDL text document.
+type textNode struct {
+	word     string
+	quoted   bool
+	group    bool // followed by a group, which makes its children a list
+	children []*textNode
+}
+
+func (t *textNode) add(word string, quoted bool) *textNode {
+	child := &textNode{word: word, quoted: quoted}
+	t.children = append(t.children, child)
+	return child
+}
+
+// node returns the OGDL flow list element of t.
+func (t *textNode) node() *Node {
+	var n *Node
+	switch {
+	case t.quoted:
+		n = &Node{Kind: ScalarNode, Text: quoteString(t.word)}
+	case len(t.word) > 1 && t.word[0] == '^':
+		n = &Node{Kind: RefNode, Text: t.word[1:]}
+	case len(t.word) > 1 && t.word[0] == '!':
+		n = &Node{Kind: TypeNode, Text: t.word[1:]}
+	case isBareKey(t.word):
+		n = &Node{Kind: ScalarNode, Text: t.word}
+	default:
+		n = &Node{Kind: ScalarNode, Text: quoteString(t.word)}
+	}
+	switch {
+	case len(t.children) == 0 && t.group:
+		n.Value = &Node{Kind: ListNode}
+	case len(t.children) == 1 && !t.group && (t.children[0].isScalar() || t.children[0].isAnnotation()):
+		n.Value = t.children[0].node()
+	case len(t.children) > 0:
+		list := &Node{Kind: ListNode}
+		for _, child := range t.children {
+			list.Children = append(list.Children, child.node())
+		}
+		n.Value = list
+	}
+	return n
+}
+
+func (t *textNode) isScalar() bool {
+	return len(t.children) == 0 && !t.group
+}
+
+func (t *textNode) isAnnotation() bool {
+	return !t.quoted && len(t.word) > 1 && (t.word[0] == '^' || t.word[0] == '!')
+}
+
+// textParser parses OGDL text into a graph of textNode.
+type textParser struct {
+	src []byte
+	pos int
+}
+
+func (p *textParser) parse(root *textNode) error {
+	type level struct {
+		indent int
+		head   *textNode
+	}
+	levels := []level{{-1, root}}
+	for p.pos < len(p.src) {
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+			p.pos++
+		}
+		indent := p.pos - start
+		if p.atLineEnd() {
+			p.skipLine()
+			continue
+		}
+		for levels[len(levels)-1].indent >= indent {
+			levels = levels[:len(levels)-1]
+		}
+		head, err := p.parseLine(levels[len(levels)-1].head, indent)
+		if err != nil {
+			return err
+		}
+		levels = append(levels, level{indent, head})
+	}
+	return nil
+}
+
+// atLineEnd reports whether the rest of the line is empty or a comment.
+func (p *textParser) atLineEnd() bool {
+	return p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' || p.src[p.pos] == '#'
+}
+
+func (p *textParser) skipLine() {
+	for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+	if p.pos < len(p.src) {
+		p.pos++
+	}
+}
+
+// parseLine parses the words of a line under parent and returns the word
+// that the words of the more indented lines below are children of.
+func (p *textParser) parseLine(parent *textNode, indent int) (*textNode, error) {
+	var (
+		head   *textNode   // the word at the start of the line or after a comma that follows it
+		cur    *textNode   // the word the next word is a child of
+		groups []*textNode // the words before the open groups
+	)
+	add := func(word string, quoted bool) {
+		switch {
+		case len(groups) == 0 && head == nil:
+			head = parent.add(word, quoted)
+			cur = head
+		case cur == nil && len(groups) > 0:
+			cur = groups[len(groups)-1].add(word, quoted)
+		case cur == nil:
+			cur = head.add(word, quoted)
+		default:
+			cur = cur.add(word, quoted)
+		}
+	}
+	for {
+		for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+			p.pos++
+		}
+		if p.atLineEnd() {
+			if len(groups) > 0 {
+				return nil, p.errorf("unclosed group")
+			}
+			p.skipLine()
+			return p.lineEnd(parent, head, cur), nil
+		}
+		switch c := p.src[p.pos]; c {
+		case ',':
+			p.pos++
+			if len(groups) == 0 && cur == head {
+				head = nil
+			}
+			cur = nil
+		case '(':
+			if cur == nil && len(groups) == 0 {
+				return nil, p.errorf("group without a word before it")
+			}
+			p.pos++
+			if cur == nil {
+				cur = groups[len(groups)-1]
+			}
+			groups = append(groups, cur)
+			cur = nil
+		case ')':
+			if len(groups) == 0 {
+				return nil, p.errorf("unexpected )")
+			}
+			p.pos++
+			g := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			g.group = true
+			cur = g
+		case '"', '\'':
+			s, err := p.parseQuoted(c)
+			if err != nil {
+				return nil, err
+			}
+			add(s, true)
+		default:
+			word := p.parseWord()
+			if word == `\` && p.blockFollows() {
+				add(p.parseBlock(indent), true)
+				return p.lineEnd(parent, head, cur), nil
+			}
+			add(word, false)
+		}
+	}
+}
+
+// lineEnd returns the word that the next word would be a child of at the
+// end of a line.
+func (p *textParser) lineEnd(parent, head, cur *textNode) *textNode {
+	switch {
+	case cur != nil:
+		return cur
+	case head != nil:
+		return head
+	}
+	return parent
+}
+
+func (p *textParser) parseWord() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		if c := p.src[p.pos]; c <= ' ' || c == ',' || c == '(' || c == ')' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// parseQuoted parses a string in double quotes with the escape sequences of
+// Go, or in single quotes with \' for a quote.
+func (p *textParser) parseQuoted(quote byte) (string, error) {
+	start := p.pos
+	for p.pos++; p.pos < len(p.src) && p.src[p.pos] != quote; p.pos++ {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos++
+		case '\n':
+			p.pos = start
+			return "", p.errorf("unterminated string")
+		}
+	}
+	if p.pos >= len(p.src) {
+		p.pos = start
+		return "", p.errorf("unterminated string")
+	}
+	p.pos++
+	s := string(p.src[start:p.pos])
+	if quote == '\'' {
+		return strings.Replace(s[1:len(s)-1], `\'`, `'`, -1), nil
+	}
+	u, err := strconv.Unquote(s)
+	if err != nil {
+		p.pos = start
+		return "", p.errorf("invalid string %s", s)
+	}
+	return u, nil
+}
+
+// blockFollows reports whether a "\" is at the end of a line.
+func (p *textParser) blockFollows() bool {
+	for i := p.pos; i < len(p.src) && p.src[i] != '\n'; i++ {
+		if c := p.src[i]; c != ' ' && c != '\t' && c != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBlock parses the lines after a "\" that are indented more than
+// indent, without the indentation of the first one.
+func (p *textParser) parseBlock(indent int) string {
+	p.skipLine()
+	var lines []string
+	blockIndent := -1
+	for p.pos < len(p.src) {
+		end := bytes.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		line := strings.TrimRight(string(p.src[p.pos:p.pos+end]), "\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		n := len(line) - len(trimmed)
+		if trimmed != "" && n <= indent {
+			break
+		}
+		if blockIndent < 0 && trimmed != "" {
+			blockIndent = n
+		}
+		if n > blockIndent && blockIndent >= 0 {
+			n = blockIndent
+		}
+		lines = append(lines, line[n:])
+		p.pos += end
+		if p.pos < len(p.src) {
+			p.pos++
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *textParser) errorf(format string, args ...interface{}) error {
+	line := bytes.Count(p.src[:p.pos], []byte("\n")) + 1
+	column := p.pos - bytes.LastIndexByte(p.src[:p.pos], '\n')
+	msg := fmt.Sprintf(format, args...)
+	return &SyntaxError{msg: msg, Offset: int64(p.pos), Line: line, Column: column}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"bytes"
+	"strconv"
+
+	"h12.io/gspec"
+)
+
+var textTestCases = []struct {
+	text, flow string
+}{
+	{"", "{}"},
+	{"a\nb\n", "{a, b}"},
+	{"a b c\n", "{a {b c}}"},
+	{"a b, c\n", "{a {b, c}}"},
+	{"a b c, d e\n", "{a {b c, d e}}"},
+	{"a, b c\n", "{a, b c}"},
+	{"a (b c, d) e\n", "{a {b c, d, e}}"},
+	{"a (b (c, d)) e\n", "{a {b {c, d}, e}}"},
+	{"a\n  b 1\n  c\n    d\n", "{a {b 1, c d}}"},
+	{"a b\n  c 1\n  d 2\ne\n", "{a {b {c 1, d 2}}, e}"},
+	{"# comment\na 1 # one\n\n\tb 2\n", "{a {1 {b 2}}}"},
+	{"a ()\n", "{a {}}"},
+	{"\"a b\" 'c\\'d'\n", "{\"a b\" \"c'd\"}"},
+	{"s \"1\"\nn 1\nw word\n", "{s \"1\", n 1, w word}"},
+	{"x {y}\n", "{x \"{y}\"}"},
+	{"t \\\n  line 1\n\n    line 2\nu 1\n", "{t `line 1\n\n  line 2`, u 1}"},
+	{"p ^1\n  a 1\nq ^1\nr !T\n  a 2\n", "{p ^1 {a 1}, q ^1, r !T {a 2}}"},
+	{"a (b), c (!T 1)\nd (e) f\n", "{a {b}, c {!T 1}, d {e, f}}"},
+	{"p (^1 (a 1))\nq\n  r (s)\n", "{p {^1 {a 1}}, q {r {s}}}"},
+}
+
+type textConfig struct {
+	Name  string
+	Port  int
+	Debug bool
+	Hosts []string
+	DB    struct{ User, Pass string }
+	Limit map[string]float64
+	Tags  []string
+}
+
+var _ = gspec.Add(func(s gspec.S) {
+	describe, testcase := s.Alias("describe"), s.Alias("testcase")
+	expect := gspec.Expect(s.Fail)
+
+	describe("ParseText", func() {
+		for _, tc := range textTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.text), func() {
+				doc, err := ParseText(bytes.NewReader([]byte(tc.text)))
+				expect(err).Equal(nil)
+				var buf bytes.Buffer
+				_, err = doc.WriteTo(&buf)
+				expect(err).Equal(nil)
+				expect(buf.String()).Equal(tc.flow + "\n")
+			})
+		}
+		testcase("errors", func() {
+			for _, src := range []string{"a (b", "a b)", "(a)", "a \"b", "a \"\\q\"", "a\n  'b"} {
+				_, err := ParseText(bytes.NewReader([]byte(src)))
+				_, ok := err.(*SyntaxError)
+				expect(ok).Equal(true)
+			}
+			_, err := ParseText(bytes.NewReader([]byte("a\nb (c")))
+			expect(err.Error()).Equal("2:5: unclosed group")
+		})
+	})
+
+	describe("WriteText", func() {
+		for _, tc := range textTestCases {
+			tc := tc
+			testcase(strconv.Quote(tc.flow), func() {
+				doc, err := Parse(bytes.NewReader([]byte(tc.flow)))
+				expect(err).Equal(nil)
+				var buf bytes.Buffer
+				_, err = doc.WriteText(&buf)
+				expect(err).Equal(nil)
+				back, err := ParseText(bytes.NewReader(buf.Bytes()))
+				expect(err).Equal(nil)
+				var again bytes.Buffer
+				_, err = back.WriteText(&again)
+				expect(err).Equal(nil)
+				expect(again.String()).Equal(buf.String())
+			})
+		}
+		testcase("layout", func() {
+			doc, _ := Parse(bytes.NewReader([]byte("{a {b 1, c \"x y\"}, d {}, e \"nil\"}")))
+			var buf bytes.Buffer
+			n, err := doc.WriteText(&buf)
+			expect(err).Equal(nil)
+			expect(buf.String()).Equal("a\n  b 1\n  c \"x y\"\nd ()\ne \"nil\"\n")
+			expect(n).Equal(int64(buf.Len()))
+		})
+		testcase("errors", func() {
+			for _, src := range []string{"1", "{{1, 2}}", "{{a} 1}"} {
+				doc, _ := Parse(bytes.NewReader([]byte(src)))
+				_, err := doc.WriteText(&bytes.Buffer{})
+				expect(err).NotEqual(nil)
+			}
+		})
+	})
+
+	describe("UnmarshalText", func() {
+		testcase("same values as flow syntax", func() {
+			src := `
+Name demo
+Port 8080
+Debug true
+Hosts
+  alpha.example.com
+  beta.example.com
+DB (User root, Pass "s3cret")
+Limit
+  cpu 1.5
+  mem 512
+Tags ()
+`
+			var fromText, fromFlow textConfig
+			expect(UnmarshalText([]byte(src), &fromText)).Equal(nil)
+			expect(Unmarshal([]byte(`{Name "demo", Port 8080, Debug true, `+
+				`Hosts {"alpha.example.com", "beta.example.com"}, `+
+				`DB {User "root", Pass "s3cret"}, Limit {cpu 1.5, mem 512}, Tags {}}`), &fromFlow)).Equal(nil)
+			expect(fromText).Equal(fromFlow)
+			expect(fromText.Port).Equal(8080)
+		})
+		testcase("MarshalText", func() {
+			v := textConfig{Name: "a b", Port: 1, Hosts: []string{"x", "y"}, Limit: map[string]float64{"cpu": 2}}
+			v.DB.User = "root"
+			text, err := MarshalText(v)
+			expect(err).Equal(nil)
+			expect(string(text)).Equal("Name \"a b\"\nPort 1\nDebug false\nHosts\n  x\n  y\n" +
+				"DB\n  User root\n  Pass \"\"\nLimit\n  cpu 2\nTags nil\n")
+			var w textConfig
+			expect(UnmarshalText(text, &w)).Equal(nil)
+			expect(w).Equal(v)
+		})
+		testcase("one element lists", func() {
+			v := textConfig{Hosts: []string{"x"}, Limit: map[string]float64{"cpu": 2}, Tags: []string{}}
+			text, err := MarshalText(v)
+			expect(err).Equal(nil)
+			expect(string(text)).Equal("Name \"\"\nPort 0\nDebug false\nHosts (x)\n" +
+				"DB\n  User \"\"\n  Pass \"\"\nLimit\n  cpu 2\nTags ()\n")
+			var w textConfig
+			expect(UnmarshalText(text, &w)).Equal(nil)
+			expect(w).Equal(v)
+
+			m := map[string][]int{"k": {1}}
+			text, err = MarshalText(m)
+			expect(err).Equal(nil)
+			expect(string(text)).Equal("k (1)\n")
+			var n map[string][]int
+			expect(UnmarshalText(text, &n)).Equal(nil)
+			expect(n).Equal(m)
+		})
+	})
+})
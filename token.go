@@ -0,0 +1,80 @@
+// Copyright 2014, Hǎiliàng Wáng. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flow
+
+import (
+	"io"
+	"strings"
+)
+
+// A Token holds a value of one of these types:
+//
+//	ListStart, for {
+//	ListEnd, for }
+//	Separator, for ,
+//	Scalar, for a quoted or unquoted string as written
+//	Ref, for a reference ^N, without the ^
+//	TypeTag, for a type annotation !T, without the !
+//	Comment, for a // or /* */ comment
+type Token interface{}
+
+type (
+	ListStart struct{}
+	ListEnd   struct{}
+	Separator struct{}
+	Scalar    string
+	Ref       string
+	TypeTag   string
+	Comment   string
+)
+
+// Unquote returns the string value of the scalar.
+func (s Scalar) Unquote() (string, error) {
+	return unquote([]byte(s))
+}
+
+// Token returns the next token in the input stream. At the end of input,
+// Token returns nil, io.EOF.
+//
+// Token can be mixed with Decode and More to decode the elements of a list
+// one at a time: inside a list started by Token, More and Decode skip the
+// separator before the next element.
+func (dec *Decoder) Token() (Token, error) {
+	if dec.pending {
+		dec.pending = false
+		if !dec.Scan() && dec.Error() != nil {
+			return nil, dec.Error()
+		}
+	}
+	var tok Token
+	switch t := dec.current(); {
+	case dec.isEOF():
+		return nil, io.EOF
+	case t.ID == tokenComment:
+		tok = Comment(strings.TrimRight(string(t.Value), "\r\n"))
+	case dec.isList():
+		dec.lists++
+		tok = ListStart{}
+	case dec.isListEnd():
+		if dec.lists == 0 {
+			return nil, dec.error()
+		}
+		dec.lists--
+		tok = ListEnd{}
+	case dec.isSep():
+		if dec.lists == 0 {
+			return nil, dec.error()
+		}
+		tok = Separator{}
+	case dec.isRef():
+		tok = Ref(t.Value[1:])
+	case dec.isType():
+		tok = TypeTag(t.Value[1:])
+	default:
+		tok = Scalar(t.Value)
+	}
+	dec.pending = true
+	return tok, nil
+}
@@ -7,11 +7,13 @@ package flow
 import (
 	"bytes"
 	"encoding"
-	"fmt"
 	"io"
+	"math"
 	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type (
@@ -51,17 +53,17 @@ func marshal(f marshalFunc, w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	if bytes.IndexAny(b, "\t {},") != -1 {
-		b = []byte(strconv.Quote(string(b)))
+	if bytes.IndexAny(b, "\t\r\n {},") != -1 {
+		b = []byte(quoteString(string(b)))
 	}
 	w.Write(b)
 	return nil
 }
 
 func unmarshal(f unmarshalFunc, val []byte, v reflect.Value) error {
-	s, err := strconv.Unquote(string(val))
+	s, err := unquote(val)
 	if err != nil {
-		return f(val)
+		return err
 	}
 	return f([]byte(s))
 }
@@ -83,6 +85,9 @@ func decodeTextMarshaler(val []byte, v reflect.Value) error {
 }
 
 func encodeBool(v reflect.Value, w io.Writer) error {
+	if b := binaryOf(w); b != nil {
+		return b.putBool(v.Bool())
+	}
 	return writeString(w, strconv.FormatBool(v.Bool()))
 }
 
@@ -93,41 +98,58 @@ func decodeBool(val []byte, v reflect.Value) error {
 	case "false":
 		v.SetBool(false)
 	default:
-		return fmt.Errorf("unexpected bool value: %s", strconv.Quote(string(val)))
+		return typeError(val, v)
 	}
 	return nil
 }
 
 func encodeInt(v reflect.Value, w io.Writer) error {
+	if b := binaryOf(w); b != nil {
+		return b.putInt(v.Int())
+	}
 	return writeString(w, strconv.FormatInt(v.Int(), 10))
 }
 
 func decodeInt(val []byte, v reflect.Value) error {
-	i := big.NewInt(0)
-	i, ok := i.SetString(string(val), 10)
+	i, ok := parseInteger(val)
 	if !ok {
-		return fmt.Errorf("unexpected int value: %s", strconv.Quote(string(val)))
+		return typeError(val, v)
+	}
+	if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+		return rangeError(val, v)
 	}
-	// TODO: handle overflow
 	v.SetInt(i.Int64())
 	return nil
 }
 
 func encodeUint(v reflect.Value, w io.Writer) error {
+	if b := binaryOf(w); b != nil {
+		return b.putUint(v.Uint())
+	}
+	if optionsOf(w).hexUint {
+		return writeString(w, "0x"+strconv.FormatUint(v.Uint(), 16))
+	}
 	return writeString(w, strconv.FormatUint(v.Uint(), 10))
 }
 
 func decodeUint(val []byte, v reflect.Value) error {
-	i := big.NewInt(0)
-	i, ok := i.SetString(string(val), 10)
+	i, ok := parseInteger(val)
 	if !ok {
-		return fmt.Errorf("unexpected int value: %s", strconv.Quote(string(val)))
+		return typeError(val, v)
+	}
+	if !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+		return rangeError(val, v)
 	}
-	// TODO: handle overflow
 	v.SetUint(i.Uint64())
 	return nil
 }
 
+// parseInteger parses an integer literal with Go's syntax, including the
+// 0x, 0o, 0b and 0 prefixes and underscores between digits.
+func parseInteger(val []byte) (*big.Int, bool) {
+	return new(big.Int).SetString(string(val), 0)
+}
+
 func encodeFloat32(v reflect.Value, w io.Writer) error {
 	return encodeFloat(v, w, 32)
 }
@@ -137,7 +159,7 @@ func decodeFloat32(val []byte, v reflect.Value) error {
 }
 
 func encodeFloat64(v reflect.Value, w io.Writer) error {
-	return encodeFloat(v, w, 32)
+	return encodeFloat(v, w, 64)
 }
 
 func decodeFloat64(val []byte, v reflect.Value) error {
@@ -145,15 +167,38 @@ func decodeFloat64(val []byte, v reflect.Value) error {
 }
 
 func encodeFloat(v reflect.Value, w io.Writer, bit int) error {
-	return writeString(w, strconv.FormatFloat(v.Float(), 'g', -1, bit))
+	if b := binaryOf(w); b != nil {
+		return b.putFloat(v.Float())
+	}
+	return writeString(w, formatFloat(v.Float(), optionsOf(w), bit))
+}
+
+func formatFloat(f float64, opts *encodeOptions, bit int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	if opts.floatFormat == 0 {
+		return strconv.FormatFloat(f, 'g', -1, bit)
+	}
+	return strconv.FormatFloat(f, opts.floatFormat, opts.floatPrec, bit)
 }
 
 func decodeFloat(val []byte, v reflect.Value, bit int) error {
 	f, err := strconv.ParseFloat(string(val), bit)
 	if err != nil {
-		return fmt.Errorf("unexpected float value: %s", strconv.Quote(string(val)))
+		if isRangeError(err) {
+			return rangeError(val, v)
+		}
+		return typeError(val, v)
+	}
+	if v.OverflowFloat(f) {
+		return rangeError(val, v)
 	}
-	// TODO: handle overflow
 	v.SetFloat(f)
 	return nil
 }
@@ -168,16 +213,17 @@ func encodeComplex128(v reflect.Value, w io.Writer) error {
 
 func encodeComplex(v reflect.Value, w io.Writer, bitSize int) error {
 	c := v.Complex()
-	r, i := real(c), imag(c)
-	if err := writeString(w, strconv.FormatFloat(r, 'g', -1, bitSize)); err != nil {
+	opts := optionsOf(w)
+	r, i := formatFloat(real(c), opts, bitSize), formatFloat(imag(c), opts, bitSize)
+	if err := writeString(w, r); err != nil {
 		return err
 	}
-	if i >= 0 {
+	if i[0] != '+' && i[0] != '-' {
 		if err := writeByte(w, '+'); err != nil {
 			return err
 		}
 	}
-	if err := writeString(w, strconv.FormatFloat(i, 'g', -1, bitSize)); err != nil {
+	if err := writeString(w, i); err != nil {
 		return err
 	}
 	if err := writeByte(w, 'i'); err != nil {
@@ -187,28 +233,90 @@ func encodeComplex(v reflect.Value, w io.Writer, bitSize int) error {
 }
 
 func decodeComplex(val []byte, v reflect.Value) error {
-	var c complex128
-	if _, err := fmt.Sscan(string(val), &c); err != nil {
-		return err
+	c, err := strconv.ParseComplex(string(val), v.Type().Bits())
+	if err != nil {
+		if isRangeError(err) {
+			return rangeError(val, v)
+		}
+		return typeError(val, v)
+	}
+	if v.OverflowComplex(c) {
+		return rangeError(val, v)
 	}
-	// TODO: handle overflow
 	v.SetComplex(c)
 	return nil
 }
 
 func encodeString(v reflect.Value, w io.Writer) error {
-	return writeString(w, strconv.Quote(v.String()))
+	if b := binaryOf(w); b != nil {
+		return b.putString(binaryString, v.String())
+	}
+	return writeString(w, quoteString(v.String()))
+}
+
+// quoteString quotes s, using a raw string for multi-line text that can be
+// written without escaping.
+func quoteString(s string) string {
+	if strings.Contains(s, "\n") && canRawQuote(s) {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+func canRawQuote(s string) bool {
+	for _, r := range s {
+		switch {
+		case r == utf8.RuneError, r == '`', r == '\uFEFF', r == '\x7f':
+			return false
+		case r < ' ' && r != '\t' && r != '\n':
+			return false
+		}
+	}
+	return true
 }
 
 func decodeString(val []byte, v reflect.Value) error {
-	s, err := strconv.Unquote(string(val))
+	s, err := unquote(val)
 	if err != nil {
-		s = string(val)
+		return err
 	}
 	v.SetString(s)
 	return nil
 }
 
+// unquote returns the string value of a scalar, which is either quoted,
+// raw quoted or unquoted.
+func unquote(val []byte) (string, error) {
+	s := string(val)
+	if s == "" || s[0] != '"' && s[0] != '`' {
+		return s, nil
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return u, nil
+	}
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", &SyntaxError{msg: "unterminated string " + strconv.Quote(s), Token: s}
+	}
+	for rest := s[1 : len(s)-1]; rest != ""; {
+		_, _, tail, err := strconv.UnquoteChar(rest, '"')
+		if err != nil {
+			seq := rest
+			if len(seq) > 2 {
+				seq = seq[:2]
+			}
+			off := len(s) - 1 - len(rest)
+			return "", &SyntaxError{
+				msg:    "invalid escape sequence " + strconv.Quote(seq),
+				Offset: int64(off),
+				Column: off,
+				Token:  s,
+			}
+		}
+		rest = tail
+	}
+	return "", &SyntaxError{msg: "invalid string " + strconv.Quote(s), Token: s}
+}
+
 func writeString(w io.Writer, s string) error {
 	_, err := w.Write([]byte(s))
 	return err